@@ -21,11 +21,69 @@ const (
 	defaultClickHouseHost         = "127.0.0.1"
 	defaultPostgresPort           = 5432
 	defaultPostgresHost           = "127.0.0.1"
+	defaultPostgresTimeZone       = "UTC"
 	defaultRowIdColumn            = "row_id"
 	defaultMaxBufferLength        = 1000
 	defaultSignColumn             = "sign"
 	defaultVerColumn              = "ver"
 	defaultIsDeletedColumn        = "is_deleted"
+	defaultToastCacheSize         = 10000
+	defaultRowStoreSuffix         = "_rows"
+	defaultOpColumn               = "op"
+	defaultLsnColumn              = "lsn"
+	defaultXidColumn              = "xid"
+	defaultCommitTimeColumn       = "commit_time"
+	defaultSeqColumn              = "seq"
+	defaultValidFromColumn        = "valid_from"
+	defaultValidToColumn          = "valid_to"
+	defaultIsCurrentColumn        = "is_current"
+	defaultZkPath                 = "/clickhouse/tables/{shard}/{database}/{table}"
+	defaultReplicaName            = "{replica}"
+)
+
+// Encodings of the binary values
+const (
+	EncodingHex    = "hex"
+	EncodingBase64 = "base64"
+)
+
+// Policies of handling nulls for the non-nullable clickhouse columns
+const (
+	NullPolicyError   = "error"   // fail
+	NullPolicyDefault = "default" // store the default value of the column type: 0, '', epoch etc
+	NullPolicyLiteral = "literal" // store the configured literal
+	NullPolicySkip    = "skip"    // skip the row
+)
+
+// Policies of handling infinite and out of range dates and timestamps
+const (
+	OutOfRangeError = "error" // fail
+	OutOfRangeClamp = "clamp" // store the min or max value of the clickhouse type
+	OutOfRangeNull  = "null"  // store null, the clickhouse column must be nullable
+)
+
+// Sources of the unchanged toasted values missing in the old row of the update
+const (
+	ToastFallbackCache      = "cache"      // the row last seen by the replicator, kept in memory
+	ToastFallbackClickHouse = "clickhouse" // the values stored in the clickhouse table
+)
+
+// Kinds of the views hiding the deleted rows of the ReplacingMergeTree tables
+const (
+	LiveViewFinal  = "final"  // SELECT ... FINAL WHERE NOT is_deleted
+	LiveViewArgMax = "argmax" // the latest versions of the columns by the primary key
+)
+
+// Representations of the interval values
+const (
+	IntervalSeconds      = "seconds"
+	IntervalMilliseconds = "milliseconds"
+)
+
+// Units of the time of day values stored in the numeric columns
+const (
+	TimeUnitSeconds      = "seconds"
+	TimeUnitMicroseconds = "microseconds"
 )
 
 type tableEngine int
@@ -39,12 +97,69 @@ const (
 
 	//MergeTree represents MergeTree table engine
 	MergeTree
+
+	//VersionedCollapsingMergeTree represents VersionedCollapsingMergeTree table engine
+	VersionedCollapsingMergeTree
+
+	//SummingMergeTree represents SummingMergeTree table engine storing the deltas of the measures
+	SummingMergeTree
+
+	//AggregatingMergeTree represents AggregatingMergeTree table engine storing the sum states of the measure deltas
+	//in the AggregateFunction(sum, T) columns
+	AggregatingMergeTree
+
+	//ReplicatedMergeTree represents ReplicatedMergeTree table engine
+	ReplicatedMergeTree
+
+	//ReplicatedReplacingMergeTree represents ReplicatedReplacingMergeTree table engine
+	ReplicatedReplacingMergeTree
+
+	//ReplicatedCollapsingMergeTree represents ReplicatedCollapsingMergeTree table engine
+	ReplicatedCollapsingMergeTree
+
+	//ReplicatedVersionedCollapsingMergeTree represents ReplicatedVersionedCollapsingMergeTree table engine
+	ReplicatedVersionedCollapsingMergeTree
+
+	//ReplicatedSummingMergeTree represents ReplicatedSummingMergeTree table engine
+	ReplicatedSummingMergeTree
+
+	//ReplicatedAggregatingMergeTree represents ReplicatedAggregatingMergeTree table engine
+	ReplicatedAggregatingMergeTree
+
+	//ChangeLog represents append-only MergeTree table storing every change of the rows with their old and new values
+	ChangeLog
+
+	//SCD2 represents VersionedCollapsingMergeTree table keeping the versions of the rows with their validity intervals
+	SCD2
 )
 
 var tableEngines = map[tableEngine]string{
-	CollapsingMergeTree: "CollapsingMergeTree",
-	ReplacingMergeTree:  "ReplacingMergeTree",
-	MergeTree:           "MergeTree",
+	CollapsingMergeTree:          "CollapsingMergeTree",
+	ReplacingMergeTree:           "ReplacingMergeTree",
+	MergeTree:                    "MergeTree",
+	VersionedCollapsingMergeTree: "VersionedCollapsingMergeTree",
+	SummingMergeTree:             "SummingMergeTree",
+	AggregatingMergeTree:         "AggregatingMergeTree",
+
+	ReplicatedMergeTree:                    "ReplicatedMergeTree",
+	ReplicatedReplacingMergeTree:           "ReplicatedReplacingMergeTree",
+	ReplicatedCollapsingMergeTree:          "ReplicatedCollapsingMergeTree",
+	ReplicatedVersionedCollapsingMergeTree: "ReplicatedVersionedCollapsingMergeTree",
+	ReplicatedSummingMergeTree:             "ReplicatedSummingMergeTree",
+	ReplicatedAggregatingMergeTree:         "ReplicatedAggregatingMergeTree",
+
+	ChangeLog: "ChangeLog",
+	SCD2:      "SCD2",
+}
+
+// replicated engines and the engines the rows are written by
+var replicatedEngines = map[tableEngine]tableEngine{
+	ReplicatedMergeTree:                    MergeTree,
+	ReplicatedReplacingMergeTree:           ReplacingMergeTree,
+	ReplicatedCollapsingMergeTree:          CollapsingMergeTree,
+	ReplicatedVersionedCollapsingMergeTree: VersionedCollapsingMergeTree,
+	ReplicatedSummingMergeTree:             SummingMergeTree,
+	ReplicatedAggregatingMergeTree:         AggregatingMergeTree,
 }
 
 type pgConnConfig struct {
@@ -52,6 +167,9 @@ type pgConnConfig struct {
 
 	ReplicationSlotName string `yaml:"replication_slot_name"`
 	PublicationName     string `yaml:"publication_name"`
+	TimeZone            string `yaml:"timezone"`
+
+	Location *time.Location `yaml:"-"` // timezone of the timestamp without time zone values
 }
 
 // PgTableName represents namespaced name
@@ -62,25 +180,110 @@ type PgTableName struct {
 
 // Table contains information about the table
 type Table struct {
-	BufferTableRowIdColumn  string            `yaml:"buffer_table_row_id"`
-	ChBufferTable           string            `yaml:"buffer_table"`
-	ChMainTable             string            `yaml:"main_table"`
-	MaxBufferLength         int               `yaml:"max_buffer_length"`
-	VerColumn               string            `yaml:"ver_column"`
-	IsDeletedColumn         string            `yaml:"is_deleted_column"`
-	SignColumn              string            `yaml:"sign_column"`
-	GenerationColumn        string            `yaml:"generation_column"`
-	Engine                  tableEngine       `yaml:"engine"`
-	FlushThreshold          int               `yaml:"flush_threshold"`
-	InitSyncSkip            bool              `yaml:"init_sync_skip"`
-	InitSyncSkipBufferTable bool              `yaml:"init_sync_skip_buffer_table"`
-	InitSyncSkipTruncate    bool              `yaml:"init_sync_skip_truncate"`
-	Columns                 map[string]string `yaml:"columns"`
+	BufferTableRowIdColumn  string                  `yaml:"buffer_table_row_id"`
+	ChBufferTable           string                  `yaml:"buffer_table"`
+	ChMainTable             string                  `yaml:"main_table"`
+	MaxBufferLength         int                     `yaml:"max_buffer_length"`
+	VerColumn               string                  `yaml:"ver_column"`
+	IsDeletedColumn         string                  `yaml:"is_deleted_column"`
+	SignColumn              string                  `yaml:"sign_column"`
+	GenerationColumn        string                  `yaml:"generation_column"`
+	Engine                  tableEngine             `yaml:"engine"`
+	FlushThreshold          int                     `yaml:"flush_threshold"`
+	InitSyncSkip            bool                    `yaml:"init_sync_skip"`
+	InitSyncSkipBufferTable bool                    `yaml:"init_sync_skip_buffer_table"`
+	InitSyncSkipTruncate    bool                    `yaml:"init_sync_skip_truncate"`
+	SyncEnums               bool                    `yaml:"sync_enums"`
+	Columns                 map[string]ColumnConfig `yaml:"columns"`
+	JSONExtract             []JSONExtraction        `yaml:"json_extract"`
+	NullPolicy              string                  `yaml:"null_policy"`
+	OutOfRange              string                  `yaml:"out_of_range"`
+	ToastFallback           []string                `yaml:"toast_fallback"`
+	ToastCacheSize          int                     `yaml:"toast_cache_size"`
+	RowImageStore           bool                    `yaml:"row_image_store"`
+	Dimensions              []string                `yaml:"dimensions"`
+	Measures                []string                `yaml:"measures"`
+	DistributedTable        string                  `yaml:"distributed_table"`
+	ShardingKey             string                  `yaml:"sharding_key"`
+	ShardWrites             bool                    `yaml:"shard_writes"`
+	ShardBy                 []string                `yaml:"shard_by"`
+	NativeIsDeleted         bool                    `yaml:"native_is_deleted"`
+	LiveView                string                  `yaml:"live_view"`
+	LiveViewMode            string                  `yaml:"live_view_mode"`
+	OpColumn                string                  `yaml:"op_column"`
+	LsnColumn               string                  `yaml:"lsn_column"`
+	XidColumn               string                  `yaml:"xid_column"`
+	CommitTimeColumn        string                  `yaml:"commit_time_column"`
+	SeqColumn               string                  `yaml:"seq_column"`
+	ValidFromColumn         string                  `yaml:"valid_from_column"`
+	ValidToColumn           string                  `yaml:"valid_to_column"`
+	IsCurrentColumn         string                  `yaml:"is_current_column"`
 
 	PgTableName   PgTableName         `yaml:"-"`
 	TupleColumns  []message.Column    `yaml:"-"` // columns in the order they are in the table
 	PgColumns     map[string]PgColumn `yaml:"-"`
 	ColumnMapping map[string]ChColumn `yaml:"-"`
+	ChColumns     map[string]ChColumn `yaml:"-"` // all columns of the main clickhouse table
+
+	RowImageStorePath string `yaml:"-"` // directory of the row image store of the table
+
+	// the engine is the replicated variant of the Engine, e.g. ReplicatedReplacingMergeTree for ReplacingMergeTree
+	Replicated bool   `yaml:"-"`
+	Cluster    string `yaml:"-"` // clickhouse cluster the DDL and the truncates are run on
+}
+
+// ColumnConfig contains settings of the postgresql column replication
+type ColumnConfig struct {
+	Target       string `yaml:"target"`        // clickhouse column name
+	PrefixColumn string `yaml:"prefix_column"` // clickhouse column for the network prefix length of the inet/cidr values
+	Encoding     string `yaml:"encoding"`      // encoding of the bytea values stored in the String columns: hex or base64
+
+	// representation of the interval values: total seconds or total milliseconds
+	IntervalFormat string `yaml:"interval_format"`
+
+	// unit of the time and timetz values stored in the numeric columns: seconds or microseconds since midnight
+	TimeUnit string `yaml:"time_unit"`
+
+	Type    string `yaml:"type"`    // clickhouse column type used by the DDL generator instead of the default one
+	Convert string `yaml:"convert"` // name of the converter used instead of the built-in conversion
+
+	LowerColumn  string `yaml:"lower_column"`  // clickhouse column for the lower bound of the range values
+	UpperColumn  string `yaml:"upper_column"`  // clickhouse column for the upper bound of the range values
+	BoundsColumn string `yaml:"bounds_column"` // clickhouse column for the range bounds inclusivity: [), [], (), (] or empty
+
+	NullPolicy  string `yaml:"null_policy"`  // overrides null policy of the table
+	NullLiteral string `yaml:"null_literal"` // value in the postgresql text format used instead of nulls by the literal policy
+
+	OutOfRange string `yaml:"out_of_range"` // overrides out of range dates policy of the table
+
+	// prefix of the clickhouse columns the fields of the composite values are flattened into,
+	// e.g. address_ for the address_city and address_zip columns
+	FieldsPrefix string   `yaml:"fields_prefix"`
+	FieldColumns []string `yaml:"-"` // clickhouse columns of the composite type fields in the order of the fields
+}
+
+// CompanionColumns returns additional clickhouse columns the postgresql column is replicated into,
+// i.e. network prefix length, range bounds and composite type fields columns
+func (c ColumnConfig) CompanionColumns() []string {
+	columns := make([]string, 0)
+	for _, column := range []string{c.PrefixColumn, c.LowerColumn, c.UpperColumn, c.BoundsColumn} {
+		if column != "" {
+			columns = append(columns, column)
+		}
+	}
+
+	return append(columns, c.FieldColumns...)
+}
+
+// JSONExtraction describes value extracted from the json/jsonb column into the separate clickhouse column
+type JSONExtraction struct {
+	Path   string `yaml:"path"`   // postgresql column followed by the keys inside the document, e.g. payload.user.id
+	Column string `yaml:"column"` // clickhouse column name
+	Type   string `yaml:"type"`   // clickhouse column type, used by the DDL generator
+
+	PgColumn string   `yaml:"-"`
+	Keys     []string `yaml:"-"` // keys of the objects or indexes of the arrays inside the document
+	ChColumn ChColumn `yaml:"-"`
 }
 
 type chConnConfig struct {
@@ -90,6 +293,10 @@ type chConnConfig struct {
 	User     string            `yaml:"username"`
 	Password string            `yaml:"password"`
 	Params   map[string]string `yaml:"params"`
+
+	Cluster     string `yaml:"cluster"`      // cluster the tables are created and truncated on
+	ZkPath      string `yaml:"zk_path"`      // zookeeper path template of the replicated tables
+	ReplicaName string `yaml:"replica_name"` // replica name template of the replicated tables
 }
 
 // Config contains config
@@ -99,6 +306,7 @@ type Config struct {
 	Tables                 map[PgTableName]Table `yaml:"tables"`
 	InactivityFlushTimeout time.Duration         `yaml:"inactivity_flush_timeout"`
 	PersStoragePath        string                `yaml:"db_path"`
+	RowStorePath           string                `yaml:"row_store_path"`
 	RedisBind              string                `yaml:"redis_bind"`
 }
 
@@ -107,11 +315,28 @@ type Column struct {
 	IsArray    bool
 	IsNullable bool
 	Ext        []int
+	ArrayDepth int            // number of nested arrays, e.g. 2 for Array(Array(Int32)); clickhouse side only
+	EnumValues map[string]int // enum labels and their codes; for postgresql enums codes follow the sort order
 }
 
 type PgColumn struct {
 	Column
-	PkCol int
+	PkCol    int
+	TimeZone *time.Location // timezone used for the timestamp without time zone values
+
+	// the earliest and the latest finite values of the date and timestamp columns according to the pg_stats,
+	// zero if unknown; used by the DDL generator to pick the clickhouse type wide enough
+	MinTime     time.Time
+	MaxTime     time.Time
+	HasInfinity bool // infinity or -infinity is among the values in the pg_stats
+
+	Fields []PgField // fields of the composite type, domains are resolved to their base types
+}
+
+// PgField describes field of the postgresql composite type
+type PgField struct {
+	PgColumn
+	Name string
 }
 
 // ChColumn describes ClickHouse column
@@ -124,6 +349,22 @@ func (t tableEngine) String() string {
 	return tableEngines[t]
 }
 
+// IsDelta checks if the engine stores the deltas of the measures grouped by the dimensions instead of the rows
+func (t tableEngine) IsDelta() bool {
+	return t == SummingMergeTree || t == AggregatingMergeTree
+}
+
+// Replicated returns the replicated variant of the engine
+func (t tableEngine) Replicated() tableEngine {
+	for replicated, engine := range replicatedEngines {
+		if engine == t {
+			return replicated
+		}
+	}
+
+	return t
+}
+
 // MarshalYAML ...
 func (t tableEngine) MarshalYAML() (interface{}, error) {
 	return tableEngines[t], nil
@@ -233,6 +474,15 @@ func New(filepath string) (*Config, error) {
 		cfg.Postgres.Host = defaultPostgresHost
 	}
 
+	if cfg.Postgres.TimeZone == "" {
+		cfg.Postgres.TimeZone = defaultPostgresTimeZone
+	}
+
+	cfg.Postgres.Location, err = time.LoadLocation(cfg.Postgres.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %q timezone: %v", cfg.Postgres.TimeZone, err)
+	}
+
 	if cfg.ClickHouse.Port == 0 {
 		cfg.ClickHouse.Port = defaultClickHousePort
 	}
@@ -241,10 +491,37 @@ func New(filepath string) (*Config, error) {
 		cfg.ClickHouse.Host = defaultClickHouseHost
 	}
 
+	if cfg.ClickHouse.ZkPath == "" {
+		cfg.ClickHouse.ZkPath = defaultZkPath
+	}
+
+	if cfg.ClickHouse.ReplicaName == "" {
+		cfg.ClickHouse.ReplicaName = defaultReplicaName
+	}
+
+	for tblName, tblCfg := range cfg.Tables {
+		if tblCfg.DistributedTable != "" && cfg.ClickHouse.Cluster == "" {
+			return nil, fmt.Errorf("cluster must be set to use distributed table of the %s table", tblName.String())
+		}
+
+		if tblCfg.ShardWrites && cfg.ClickHouse.Cluster == "" {
+			return nil, fmt.Errorf("cluster must be set to write the %s table to the shards", tblName.String())
+		}
+
+		// the rows written to the shards are routed by cityHash64 of the shard_by columns
+		if tblCfg.ShardWrites && tblCfg.ShardingKey != "" {
+			return nil, fmt.Errorf("sharding_key can't be set for the %s table written to the shards", tblName.String())
+		}
+	}
+
 	if cfg.PersStoragePath == "" {
 		return nil, fmt.Errorf("db_filepath is not set")
 	}
 
+	if cfg.RowStorePath == "" {
+		cfg.RowStorePath = strings.TrimRight(cfg.PersStoragePath, "/") + defaultRowStoreSuffix
+	}
+
 	return &cfg, nil
 }
 
@@ -257,11 +534,18 @@ func (t *Table) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
+	// the replicated engines are written to the same way as the plain ones
+	if engine, ok := replicatedEngines[val.Engine]; ok {
+		val.Engine = engine
+		val.Replicated = true
+	}
+
 	if val.ChBufferTable != "" && val.BufferTableRowIdColumn == "" {
 		val.BufferTableRowIdColumn = defaultRowIdColumn
 	}
 
-	if val.SignColumn == "" && val.Engine == CollapsingMergeTree {
+	if val.SignColumn == "" &&
+		(val.Engine == CollapsingMergeTree || val.Engine == VersionedCollapsingMergeTree || val.Engine == SCD2) {
 		val.SignColumn = defaultSignColumn
 	}
 
@@ -269,19 +553,211 @@ func (t *Table) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		val.IsDeletedColumn = defaultIsDeletedColumn
 	}
 
-	if val.VerColumn == "" && val.Engine == ReplacingMergeTree {
+	if val.VerColumn == "" && (val.Engine == ReplacingMergeTree || val.Engine == VersionedCollapsingMergeTree) {
 		val.VerColumn = defaultVerColumn
 	}
 
+	if val.Engine == ChangeLog {
+		if val.GenerationColumn != "" || val.ShardWrites || val.SyncEnums {
+			return fmt.Errorf("generation_column, shard_writes and sync_enums are not supported by the ChangeLog engine")
+		}
+
+		if val.OpColumn == "" {
+			val.OpColumn = defaultOpColumn
+		}
+
+		if val.LsnColumn == "" {
+			val.LsnColumn = defaultLsnColumn
+		}
+
+		if val.XidColumn == "" {
+			val.XidColumn = defaultXidColumn
+		}
+
+		if val.CommitTimeColumn == "" {
+			val.CommitTimeColumn = defaultCommitTimeColumn
+		}
+
+		if val.SeqColumn == "" {
+			val.SeqColumn = defaultSeqColumn
+		}
+	}
+
+	if val.Engine == SCD2 {
+		if val.ValidFromColumn == "" {
+			val.ValidFromColumn = defaultValidFromColumn
+		}
+
+		if val.ValidToColumn == "" {
+			val.ValidToColumn = defaultValidToColumn
+		}
+
+		if val.IsCurrentColumn == "" {
+			val.IsCurrentColumn = defaultIsCurrentColumn
+		}
+	}
+
 	if val.MaxBufferLength == 0 {
 		val.MaxBufferLength = defaultMaxBufferLength
 	}
 
+	switch val.NullPolicy {
+	case "", NullPolicyError, NullPolicyDefault, NullPolicySkip:
+	case NullPolicyLiteral:
+		return fmt.Errorf("literal null policy can be set for the columns only")
+	default:
+		return fmt.Errorf("unknown null policy: %q", val.NullPolicy)
+	}
+
+	if err := checkOutOfRangePolicy(val.OutOfRange); err != nil {
+		return err
+	}
+
+	if (val.NativeIsDeleted || val.LiveView != "") && val.Engine != ReplacingMergeTree {
+		return fmt.Errorf("native_is_deleted and live_view can be set for the ReplacingMergeTree engine only")
+	}
+
+	if val.NativeIsDeleted && val.VerColumn == "" {
+		return fmt.Errorf("native_is_deleted requires version column")
+	}
+
+	switch val.LiveViewMode {
+	case "":
+		val.LiveViewMode = LiveViewFinal
+	case LiveViewFinal, LiveViewArgMax:
+	default:
+		return fmt.Errorf("unknown live view mode: %q", val.LiveViewMode)
+	}
+
+	if val.Engine.IsDelta() && len(val.Measures) == 0 {
+		return fmt.Errorf("measures must be specified for the %s engine", val.Engine)
+	}
+
+	for _, fallback := range val.ToastFallback {
+		switch fallback {
+		case ToastFallbackCache:
+			if val.ToastCacheSize == 0 {
+				val.ToastCacheSize = defaultToastCacheSize
+			}
+		case ToastFallbackClickHouse:
+			if val.Engine == ChangeLog {
+				return fmt.Errorf("clickhouse toast fallback is not supported by the ChangeLog engine")
+			}
+		default:
+			return fmt.Errorf("unknown toast fallback: %q", fallback)
+		}
+	}
+
 	*t = Table(val)
 
 	return nil
 }
 
+// UnmarshalYAML accepts either clickhouse column name or the column settings
+func (c *ColumnConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type alias ColumnConfig
+
+	var target string
+	if err := unmarshal(&target); err == nil {
+		*c = ColumnConfig{Target: target}
+		return nil
+	}
+
+	var val alias
+	if err := unmarshal(&val); err != nil {
+		return err
+	}
+
+	if val.Target == "" && val.LowerColumn == "" && val.UpperColumn == "" && val.BoundsColumn == "" &&
+		val.FieldsPrefix == "" {
+		return fmt.Errorf("target column is not specified")
+	}
+
+	switch val.Encoding {
+	case "", EncodingHex, EncodingBase64:
+	default:
+		return fmt.Errorf("unknown encoding: %q", val.Encoding)
+	}
+
+	switch val.IntervalFormat {
+	case "", IntervalSeconds, IntervalMilliseconds:
+	default:
+		return fmt.Errorf("unknown interval format: %q", val.IntervalFormat)
+	}
+
+	switch val.TimeUnit {
+	case "", TimeUnitSeconds, TimeUnitMicroseconds:
+	default:
+		return fmt.Errorf("unknown time unit: %q", val.TimeUnit)
+	}
+
+	switch val.NullPolicy {
+	case "", NullPolicyError, NullPolicyDefault, NullPolicyLiteral, NullPolicySkip:
+	default:
+		return fmt.Errorf("unknown null policy: %q", val.NullPolicy)
+	}
+
+	if err := checkOutOfRangePolicy(val.OutOfRange); err != nil {
+		return err
+	}
+
+	*c = ColumnConfig(val)
+
+	return nil
+}
+
+func checkOutOfRangePolicy(policy string) error {
+	switch policy {
+	case "", OutOfRangeError, OutOfRangeClamp, OutOfRangeNull:
+	default:
+		return fmt.Errorf("unknown out of range policy: %q", policy)
+	}
+
+	return nil
+}
+
+// UnmarshalYAML accepts either the extraction settings or its short form: {path} -> {column} [{type}],
+// e.g. payload.user.id -> user_id UInt64
+func (e *JSONExtraction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type alias JSONExtraction
+
+	var (
+		val alias
+		str string
+	)
+
+	if err := unmarshal(&str); err == nil {
+		parts := strings.SplitN(str, "->", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid json extraction %q, expected {path} -> {column} [{type}]", str)
+		}
+
+		val.Path = strings.TrimSpace(parts[0])
+		target := strings.SplitN(strings.TrimSpace(parts[1]), " ", 2)
+		val.Column = target[0]
+		if len(target) == 2 {
+			val.Type = strings.TrimSpace(target[1])
+		}
+	} else if err := unmarshal(&val); err != nil {
+		return err
+	}
+
+	keys := strings.Split(val.Path, ".")
+	if len(keys) < 2 || keys[0] == "" {
+		return fmt.Errorf("json path must start with the postgresql column name: %q", val.Path)
+	}
+
+	if val.Column == "" {
+		return fmt.Errorf("clickhouse column is not specified for the %q json path", val.Path)
+	}
+
+	val.PgColumn, val.Keys = keys[0], keys[1:]
+
+	*e = JSONExtraction(val)
+
+	return nil
+}
+
 // ConnectionString returns clickhouse connection string
 func (c *chConnConfig) ConnectionString() string {
 	connStr := url.Values{}
@@ -296,3 +772,22 @@ func (c *chConnConfig) ConnectionString() string {
 
 	return fmt.Sprintf("tcp://%s:%d?%s", c.Host, c.Port, connStr.Encode())
 }
+
+// ShardConnectionString returns connection string of the cluster shard given the host:port addresses of its replicas,
+// the replicas are connected to in order
+func (c *chConnConfig) ShardConnectionString(replicas []string) string {
+	connStr := url.Values{}
+
+	connStr.Add("username", c.User)
+	connStr.Add("password", c.Password)
+	connStr.Add("database", c.Database)
+
+	for param, value := range c.Params {
+		connStr.Add(param, value)
+	}
+
+	connStr.Set("alt_hosts", strings.Join(replicas[1:], ","))
+	connStr.Set("connection_open_strategy", "in_order")
+
+	return fmt.Sprintf("tcp://%s?%s", replicas[0], connStr.Encode())
+}
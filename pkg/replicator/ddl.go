@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
 	"github.com/mkabilov/pg2ch/pkg/utils/chutils"
 	"github.com/mkabilov/pg2ch/pkg/utils/tableinfo"
 )
@@ -30,35 +31,85 @@ func (r *Replicator) GenerateChDDL() error {
 
 		tblCfg := r.cfg.Tables[tblName]
 
-		tblCfg.TupleColumns, tblCfg.PgColumns, err = tableinfo.TablePgColumns(tx, tblName)
+		tblCfg.TupleColumns, tblCfg.PgColumns, err = r.tablePgColumns(tx, tblName)
 		if err != nil {
 			return fmt.Errorf("could not get columns for %s postgres table: %v", tblName.String(), err)
 		}
 
+		if err := tableinfo.SetTimeStats(tx, tblName, tblCfg.PgColumns); err != nil {
+			return fmt.Errorf("could not get statistics of %s postgres table: %v", tblName.String(), err)
+		}
+
 		if len(tblCfg.Columns) == 0 {
-			tblCfg.Columns = make(map[string]string)
+			tblCfg.Columns = make(map[string]config.ColumnConfig)
 			for _, pgCol := range tblCfg.TupleColumns {
-				tblCfg.Columns[pgCol.Name] = pgCol.Name
+				if tblCfg.Engine.IsDelta() && !isDeltaColumn(tblCfg, pgCol.Name) {
+					continue
+				}
+				tblCfg.Columns[pgCol.Name] = config.ColumnConfig{Target: pgCol.Name}
+			}
+		}
+
+		measures := make(map[string]struct{})
+		for _, pgColName := range tblCfg.Measures {
+			measures[pgColName] = struct{}{}
+		}
+
+		jsonExtractions := make(map[string][]config.JSONExtraction)
+		for _, extraction := range tblCfg.JSONExtract {
+			if extraction.Type == "" {
+				return fmt.Errorf("type of the %q column extracted from %q is not specified",
+					extraction.Column, extraction.Path)
 			}
+			jsonExtractions[extraction.PgColumn] = append(jsonExtractions[extraction.PgColumn], extraction)
 		}
 
 		chColumnDDLs := make([]string, 0)
+		aggColumnDDLs := make(map[int]string) // main table definitions of the AggregatingMergeTree measure columns
 		for _, pgCol := range tblCfg.TupleColumns {
-			chColName, ok := tblCfg.Columns[pgCol.Name]
-			if !ok {
-				continue
-			}
+			_, isMeasure := measures[pgCol.Name]
 
-			pgCol := tblCfg.PgColumns[pgCol.Name]
-			chColDDL, err := chutils.ToClickHouseType(pgCol)
-			if err != nil {
-				return fmt.Errorf("could not get clickhouse column definition: %v", err)
-			}
-			if pgCol.PkCol > 0 && pgCol.PkCol > pkColumnNumb {
-				pkColumnNumb = pgCol.PkCol
+			if colCfg, ok := tblCfg.Columns[pgCol.Name]; ok {
+				pgCol := tblCfg.PgColumns[pgCol.Name]
+				if colCfg.OutOfRange == "" {
+					colCfg.OutOfRange = tblCfg.OutOfRange
+				}
+
+				if colCfg.Target != "" {
+					chColDDL := colCfg.Type
+					if chColDDL == "" && isMeasure {
+						chColDDL, err = chutils.MeasureType(pgCol)
+						if err != nil {
+							return fmt.Errorf("could not get clickhouse column definition: %v", err)
+						}
+					} else if chColDDL == "" {
+						chColDDL, err = chutils.ToClickHouseType(pgCol, colCfg)
+						if err != nil {
+							return fmt.Errorf("could not get clickhouse column definition: %v", err)
+						}
+					}
+
+					if isMeasure && tblCfg.Engine == config.AggregatingMergeTree {
+						aggColumnDDLs[len(chColumnDDLs)] = fmt.Sprintf("    %s AggregateFunction(sum, %s)",
+							colCfg.Target, chColDDL)
+					}
+
+					chColumnDDLs = append(chColumnDDLs, fmt.Sprintf("    %s %s", colCfg.Target, chColDDL))
+				}
+				if pgCol.PkCol > 0 && pgCol.PkCol > pkColumnNumb {
+					pkColumnNumb = pgCol.PkCol
+				}
+
+				companionDDLs, err := companionColumnDDLs(pgCol, colCfg)
+				if err != nil {
+					return fmt.Errorf("could not get clickhouse column definition: %v", err)
+				}
+				chColumnDDLs = append(chColumnDDLs, companionDDLs...)
 			}
 
-			chColumnDDLs = append(chColumnDDLs, fmt.Sprintf("    %s %s", chColName, chColDDL))
+			for _, extraction := range jsonExtractions[pgCol.Name] {
+				chColumnDDLs = append(chColumnDDLs, fmt.Sprintf("    %s %s", extraction.Column, extraction.Type))
+			}
 		}
 		pkColumns := make([]string, pkColumnNumb)
 
@@ -71,6 +122,11 @@ func (r *Replicator) GenerateChDDL() error {
 			pkColumns[pgCol.PkCol-1] = pgColName
 		}
 
+		dataColumns := make([]string, len(chColumnDDLs))
+		for i, ddl := range chColumnDDLs {
+			dataColumns[i] = strings.Fields(ddl)[0]
+		}
+
 		if tblCfg.GenerationColumn != "" {
 			chColumnDDLs = append(chColumnDDLs, fmt.Sprintf("    %s UInt32", tblCfg.GenerationColumn))
 		}
@@ -85,26 +141,126 @@ func (r *Replicator) GenerateChDDL() error {
 			}
 
 			chColumnDDLs = append(chColumnDDLs, fmt.Sprintf("    %s UInt8", tblCfg.IsDeletedColumn))
+			if tblCfg.NativeIsDeleted { // supported since clickhouse 23.2
+				engineParams += ", " + tblCfg.IsDeletedColumn
+			}
 		case config.CollapsingMergeTree:
 			engineParams = tblCfg.SignColumn
 			chColumnDDLs = append(chColumnDDLs, fmt.Sprintf("    %s Int8", engineParams))
+		case config.VersionedCollapsingMergeTree:
+			engineParams = fmt.Sprintf("%s, %s", tblCfg.SignColumn, tblCfg.VerColumn)
+			chColumnDDLs = append(chColumnDDLs,
+				fmt.Sprintf("    %s Int8", tblCfg.SignColumn),
+				fmt.Sprintf("    %s UInt64", tblCfg.VerColumn))
+		case config.SummingMergeTree:
+			measureColumns := make([]string, 0, len(tblCfg.Measures))
+			for _, pgColName := range tblCfg.Measures {
+				measureColumns = append(measureColumns, tblCfg.Columns[pgColName].Target)
+			}
+			engineParams = fmt.Sprintf("(%s)", strings.Join(measureColumns, ", "))
+		case config.ChangeLog:
+			changeColumnDDLs := []string{
+				fmt.Sprintf("    %s Enum8('insert' = 1, 'update' = 2, 'delete' = 3, 'truncate' = 4, 'snapshot' = 5)",
+					tblCfg.OpColumn),
+				fmt.Sprintf("    %s UInt64", tblCfg.LsnColumn),
+				fmt.Sprintf("    %s UInt32", tblCfg.XidColumn),
+				fmt.Sprintf("    %s DateTime64(6)", tblCfg.CommitTimeColumn),
+				fmt.Sprintf("    %s UInt32", tblCfg.SeqColumn),
+			}
+			// values missing for the kind of the change are nulls
+			for _, prefix := range []string{"old_", "new_"} {
+				for _, ddl := range chColumnDDLs {
+					colDDL := strings.SplitN(strings.TrimLeft(ddl, " "), " ", 2)
+					changeColumnDDLs = append(changeColumnDDLs,
+						fmt.Sprintf("    %s%s %s", prefix, colDDL[0], changeLogType(colDDL[1])))
+				}
+			}
+			chColumnDDLs = changeColumnDDLs
+		case config.SCD2:
+			// the current version is cancelled by the row of the same is_current version
+			engineParams = fmt.Sprintf("%s, %s", tblCfg.SignColumn, tblCfg.IsCurrentColumn)
+			chColumnDDLs = append(chColumnDDLs,
+				fmt.Sprintf("    %s DateTime64(6)", tblCfg.ValidFromColumn),
+				fmt.Sprintf("    %s DateTime64(6)", tblCfg.ValidToColumn),
+				fmt.Sprintf("    %s UInt8", tblCfg.IsCurrentColumn),
+				fmt.Sprintf("    %s Int8", tblCfg.SignColumn))
+		}
+
+		mainColumnDDLs := make([]string, len(chColumnDDLs))
+		copy(mainColumnDDLs, chColumnDDLs)
+		for i, ddl := range aggColumnDDLs {
+			mainColumnDDLs[i] = ddl
 		}
 
-		tableDDL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n) Engine = %s(%s)",
-			tblCfg.ChMainTable,
-			strings.Join(chColumnDDLs, ",\n"),
-			tblCfg.Engine.String(), engineParams)
+		engine := tblCfg.Engine
+		if engine == config.ChangeLog {
+			engine = config.MergeTree
+		} else if engine == config.SCD2 {
+			engine = config.VersionedCollapsingMergeTree
+		}
+		if tblCfg.Replicated {
+			engine = engine.Replicated()
+			engineParams = r.replicationParams(tblCfg.ChMainTable, engineParams)
+		}
+
+		onCluster := ""
+		if r.cfg.ClickHouse.Cluster != "" {
+			onCluster = fmt.Sprintf(" ON CLUSTER %s", r.cfg.ClickHouse.Cluster)
+		}
 
-		if len(pkColumns) > 0 {
+		tableDDL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s (\n%s\n) Engine = %s(%s)",
+			tblCfg.ChMainTable, onCluster,
+			strings.Join(mainColumnDDLs, ",\n"),
+			engine.String(), engineParams)
+
+		if tblCfg.Engine.IsDelta() {
+			// the deltas are summed up by the dimensions
+			dimColumns := make([]string, 0, len(tblCfg.Dimensions))
+			for _, pgColName := range tblCfg.Dimensions {
+				dimColumns = append(dimColumns, tblCfg.Columns[pgColName].Target)
+			}
+
+			orderBy = " ORDER BY tuple()"
+			if len(dimColumns) > 0 {
+				orderBy = fmt.Sprintf(" ORDER BY(%s)", strings.Join(dimColumns, ", "))
+			}
+		} else if tblCfg.Engine == config.ChangeLog {
+			orderBy = fmt.Sprintf(" ORDER BY(%s, %s)", tblCfg.LsnColumn, tblCfg.SeqColumn)
+		} else if tblCfg.Engine == config.SCD2 {
+			// versions of the row are collapsed by the key and the time they are valid since
+			if len(pkColumns) == 0 {
+				return fmt.Errorf("table %s has no primary key, which is required by the SCD2 engine", tblName.String())
+			}
+			orderBy = fmt.Sprintf(" ORDER BY(%s, %s)", strings.Join(pkColumns, ", "), tblCfg.ValidFromColumn)
+		} else if len(pkColumns) > 0 {
 			orderBy = fmt.Sprintf(" ORDER BY(%s)", strings.Join(pkColumns, ", "))
 		}
 		tableDDL += orderBy + ";"
 
 		fmt.Println(tableDDL)
 
+		if tblCfg.DistributedTable != "" {
+			fmt.Println(r.distributedTableDDL(tblCfg, pkColumns))
+		}
+
+		if tblCfg.LiveView != "" {
+			viewDDL, err := liveViewDDL(tblCfg, dataColumns, pkColumns, onCluster)
+			if err != nil {
+				return fmt.Errorf("could not generate live view of %s table: %v", tblName.String(), err)
+			}
+
+			fmt.Println(viewDDL)
+		}
+
+		// buffer table is local to the node pg2ch is connected to, unless the rows are written to every shard
 		if tblCfg.ChBufferTable != "" {
-			fmt.Println(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n) Engine = MergeTree()%s;",
-				tblCfg.ChBufferTable,
+			bufOnCluster := ""
+			if tblCfg.ShardWrites {
+				bufOnCluster = onCluster
+			}
+
+			fmt.Println(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s (\n%s\n) Engine = MergeTree()%s;",
+				tblCfg.ChBufferTable, bufOnCluster,
 				strings.Join(
 					append(chColumnDDLs, fmt.Sprintf("    %s UInt64", tblCfg.BufferTableRowIdColumn)), ",\n"),
 				orderBy))
@@ -114,3 +270,168 @@ func (r *Replicator) GenerateChDDL() error {
 
 	return nil
 }
+
+// replicationParams prepends the zookeeper path and the replica name to the parameters of the replicated engine
+func (r *Replicator) replicationParams(chTableName string, engineParams string) string {
+	replacements := []string{"{table}", chTableName}
+	if r.cfg.ClickHouse.Database != "" {
+		replacements = append(replacements, "{database}", r.cfg.ClickHouse.Database)
+	}
+	zkPath := strings.NewReplacer(replacements...).Replace(r.cfg.ClickHouse.ZkPath)
+
+	params := fmt.Sprintf("'%s', '%s'", zkPath, r.cfg.ClickHouse.ReplicaName)
+	if engineParams != "" {
+		params += ", " + engineParams
+	}
+
+	return params
+}
+
+// distributedTableDDL returns definition of the distributed table in front of the main table; rows are sharded
+// by the sharding key, by default the hash of the shard_by columns or the primary key, so all the versions
+// of the row get to the same shard
+func (r *Replicator) distributedTableDDL(tblCfg config.Table, pkColumns []string) string {
+	shardingKey := tblCfg.ShardingKey
+	if shardingKey == "" {
+		if len(tblCfg.ShardBy) > 0 {
+			pkColumns = tblCfg.ShardBy
+		}
+
+		keyColumns := make([]string, 0, len(pkColumns))
+		for _, pgColName := range pkColumns {
+			if target := tblCfg.Columns[pgColName].Target; target != "" {
+				keyColumns = append(keyColumns, target)
+			}
+		}
+
+		shardingKey = "rand()"
+		if len(keyColumns) > 0 {
+			shardingKey = fmt.Sprintf("cityHash64(%s)", strings.Join(keyColumns, ", "))
+		}
+	}
+
+	database := r.cfg.ClickHouse.Database
+	if database == "" {
+		database = "currentDatabase()"
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %[1]s ON CLUSTER %[2]s AS %[3]s Engine = Distributed(%[2]s, %[4]s, %[3]s, %[5]s);",
+		tblCfg.DistributedTable, r.cfg.ClickHouse.Cluster, tblCfg.ChMainTable, database, shardingKey)
+}
+
+// liveViewDDL returns definition of the view hiding the deleted rows of the ReplacingMergeTree table: either
+// the FINAL one, or the one taking the latest versions of the columns by the primary key, which doesn't need FINAL
+func liveViewDDL(tblCfg config.Table, dataColumns []string, pkColumns []string, onCluster string) (string, error) {
+	source := tblCfg.ChMainTable
+	if tblCfg.DistributedTable != "" {
+		source = tblCfg.DistributedTable
+	}
+
+	if tblCfg.LiveViewMode != config.LiveViewArgMax {
+		return fmt.Sprintf("CREATE VIEW IF NOT EXISTS %s%s AS SELECT %s FROM %s FINAL WHERE NOT %s;",
+			tblCfg.LiveView, onCluster, strings.Join(dataColumns, ", "), source, tblCfg.IsDeletedColumn), nil
+	}
+
+	if len(pkColumns) == 0 {
+		return "", fmt.Errorf("table has no primary key, which is required by the argmax live view")
+	}
+
+	version := tblCfg.VerColumn
+	if version == "" {
+		version = tblCfg.GenerationColumn
+	}
+
+	keyColumns := make([]string, len(pkColumns))
+	isKey := make(map[string]struct{}, len(pkColumns))
+	for i, pgColName := range pkColumns {
+		keyColumns[i] = tblCfg.Columns[pgColName].Target
+		isKey[keyColumns[i]] = struct{}{}
+	}
+
+	selects := make([]string, len(dataColumns))
+	for i, column := range dataColumns {
+		if _, ok := isKey[column]; ok {
+			selects[i] = column
+		} else {
+			selects[i] = fmt.Sprintf("argMax(%[1]s, %[2]s) AS %[1]s", column, version)
+		}
+	}
+
+	return fmt.Sprintf("CREATE VIEW IF NOT EXISTS %s%s AS SELECT %s FROM %s GROUP BY %s HAVING argMax(%s, %s) = 0;",
+		tblCfg.LiveView, onCluster, strings.Join(selects, ", "), source, strings.Join(keyColumns, ", "),
+		tblCfg.IsDeletedColumn, version), nil
+}
+
+// companionColumnDDLs returns definitions of the additional clickhouse columns the postgresql column is replicated into
+func companionColumnDDLs(pgCol config.PgColumn, colCfg config.ColumnConfig) ([]string, error) {
+	ddls := make([]string, 0)
+
+	if colCfg.PrefixColumn != "" {
+		ddls = append(ddls, fmt.Sprintf("    %s %s", colCfg.PrefixColumn, nullableType(utils.ChUInt8, pgCol.IsNullable)))
+	}
+
+	if colCfg.LowerColumn != "" || colCfg.UpperColumn != "" {
+		subtype, ok := utils.RangeSubtypes[pgCol.BaseType]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a range type", pgCol.BaseType)
+		}
+
+		// unbounded sides are stored as nulls
+		boundCol := config.PgColumn{Column: config.Column{BaseType: subtype, IsNullable: true}, TimeZone: pgCol.TimeZone}
+		boundType := nullableType(utils.ChFloat64, true) // numrange has neither precision nor scale
+		if subtype != utils.PgNumeric {
+			var err error
+			if boundType, err = chutils.ToClickHouseType(boundCol, config.ColumnConfig{}); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, column := range []string{colCfg.LowerColumn, colCfg.UpperColumn} {
+			if column != "" {
+				ddls = append(ddls, fmt.Sprintf("    %s %s", column, boundType))
+			}
+		}
+	}
+
+	if colCfg.BoundsColumn != "" {
+		ddls = append(ddls, fmt.Sprintf("    %s %s", colCfg.BoundsColumn, nullableType(utils.ChString, pgCol.IsNullable)))
+	}
+
+	if colCfg.FieldsPrefix != "" {
+		if len(pgCol.Fields) == 0 {
+			return nil, fmt.Errorf("%s is not a composite type", pgCol.BaseType)
+		}
+
+		for _, field := range pgCol.Fields {
+			fieldType, err := chutils.ToClickHouseType(field.PgColumn, config.ColumnConfig{OutOfRange: colCfg.OutOfRange})
+			if err != nil {
+				return nil, fmt.Errorf("could not get type of the %q field: %v", field.Name, err)
+			}
+
+			ddls = append(ddls, fmt.Sprintf("    %s%s %s", colCfg.FieldsPrefix, field.Name, fieldType))
+		}
+	}
+
+	return ddls, nil
+}
+
+func nullableType(chType string, isNullable bool) string {
+	if !isNullable {
+		return chType
+	}
+
+	return fmt.Sprintf("Nullable(%s)", chType)
+}
+
+// changeLogType returns the nullable type of the old_ and new_ change log columns, arrays can't be nullable
+func changeLogType(chType string) string {
+	if strings.HasPrefix(chType, "Nullable(") || strings.HasPrefix(chType, "Array(") {
+		return chType
+	}
+
+	if strings.HasPrefix(chType, "LowCardinality(") && !strings.HasPrefix(chType, "LowCardinality(Nullable(") {
+		return fmt.Sprintf("LowCardinality(Nullable(%s))", chType[15:len(chType)-1])
+	}
+
+	return nullableType(chType, true)
+}
@@ -0,0 +1,87 @@
+package replicator
+
+import (
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+)
+
+func TestReplicationParams(t *testing.T) {
+	tests := []struct {
+		database     string
+		zkPath       string
+		engineParams string
+		expected     string
+	}{
+		{
+			zkPath:   "/clickhouse/tables/{shard}/{table}",
+			expected: "'/clickhouse/tables/{shard}/sales', '{replica}'",
+		},
+		{
+			database:     "dwh",
+			zkPath:       "/clickhouse/tables/{shard}/{database}/{table}",
+			engineParams: "ver",
+			expected:     "'/clickhouse/tables/{shard}/dwh/sales', '{replica}', ver",
+		},
+		{
+			zkPath:   "/clickhouse/{database}/{table}",
+			expected: "'/clickhouse/{database}/sales', '{replica}'",
+		},
+	}
+
+	for _, tt := range tests {
+		r := &Replicator{}
+		r.cfg.ClickHouse.Database = tt.database
+		r.cfg.ClickHouse.ZkPath = tt.zkPath
+		r.cfg.ClickHouse.ReplicaName = "{replica}"
+
+		if res := r.replicationParams("sales", tt.engineParams); res != tt.expected {
+			t.Errorf("replicationParams(%q, %q) = %q, expected %q", tt.zkPath, tt.engineParams, res, tt.expected)
+		}
+	}
+}
+
+func TestDistributedTableDDL(t *testing.T) {
+	tests := []struct {
+		database    string
+		shardingKey string
+		pkColumns   []string
+		expected    string
+	}{
+		{
+			pkColumns: []string{"id", "region"},
+			expected: "CREATE TABLE IF NOT EXISTS sales_all ON CLUSTER main AS sales " +
+				"Engine = Distributed(main, currentDatabase(), sales, cityHash64(sale_id, region));",
+		},
+		{
+			database: "dwh",
+			expected: "CREATE TABLE IF NOT EXISTS sales_all ON CLUSTER main AS sales " +
+				"Engine = Distributed(main, dwh, sales, rand());",
+		},
+		{
+			shardingKey: "intHash32(customer_id)",
+			pkColumns:   []string{"id"},
+			expected: "CREATE TABLE IF NOT EXISTS sales_all ON CLUSTER main AS sales " +
+				"Engine = Distributed(main, currentDatabase(), sales, intHash32(customer_id));",
+		},
+	}
+
+	for _, tt := range tests {
+		r := &Replicator{}
+		r.cfg.ClickHouse.Cluster = "main"
+		r.cfg.ClickHouse.Database = tt.database
+		tblCfg := config.Table{
+			ChMainTable:      "sales",
+			DistributedTable: "sales_all",
+			ShardingKey:      tt.shardingKey,
+			Columns: map[string]config.ColumnConfig{
+				"id":     {Target: "sale_id"},
+				"region": {Target: "region"},
+			},
+		}
+
+		if res := r.distributedTableDDL(tblCfg, tt.pkColumns); res != tt.expected {
+			t.Errorf("distributedTableDDL(%v) = %q, expected %q", tt.pkColumns, res, tt.expected)
+		}
+	}
+}
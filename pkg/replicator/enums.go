@@ -0,0 +1,115 @@
+package replicator
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils/chutils"
+)
+
+// checkEnums looks for the postgresql enum labels missing in the clickhouse enum columns, e.g. added with
+// ALTER TYPE ... ADD VALUE; missing labels are either reported or, if sync_enums is set, added to the clickhouse columns
+func (r *Replicator) checkEnums(cfg *config.Table) error {
+	for pgColName, chCol := range cfg.ColumnMapping {
+		pgCol := cfg.PgColumns[pgColName]
+		if pgCol.EnumValues == nil || chCol.EnumValues == nil {
+			continue
+		}
+
+		missing := missingEnumLabels(pgCol.EnumValues, chCol.EnumValues)
+		if len(missing) == 0 {
+			continue
+		}
+
+		if !cfg.SyncEnums {
+			log.Printf("%q column of the %q clickhouse table lacks values of the %q postgresql enum type: %s",
+				chCol.Name, cfg.ChMainTable, pgCol.BaseType, strings.Join(missing, ", "))
+			continue
+		}
+
+		values := appendEnumLabels(chCol.EnumValues, missing)
+		enumType := chutils.EnumType(values)
+		for _, query := range r.enumAlterQueries(cfg, chCol.Name, enumColumnType(chCol, enumType)) {
+			if _, err := r.chConn.Exec(query); err != nil {
+				return fmt.Errorf("could not add enum values to the %q column: %v", chCol.Name, err)
+			}
+		}
+
+		log.Printf("%s values added to the %q column of the %q clickhouse table",
+			strings.Join(missing, ", "), chCol.Name, cfg.ChMainTable)
+
+		chCol.BaseType = enumType[:strings.IndexByte(enumType, '(')]
+		chCol.EnumValues = values
+		cfg.ColumnMapping[pgColName] = chCol
+	}
+
+	return nil
+}
+
+// missingEnumLabels returns labels absent in the clickhouse enum in the postgresql sort order
+func missingEnumLabels(pgValues, chValues map[string]int) []string {
+	missing := make([]string, 0)
+	for label := range pgValues {
+		if _, ok := chValues[label]; !ok {
+			missing = append(missing, label)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return pgValues[missing[i]] < pgValues[missing[j]] })
+
+	return missing
+}
+
+// appendEnumLabels returns the clickhouse enum values with the missing labels coded after the greatest code
+func appendEnumLabels(chValues map[string]int, missing []string) map[string]int {
+	maxCode := 0
+	values := make(map[string]int, len(chValues)+len(missing))
+	for label, code := range chValues {
+		values[label] = code
+		if code > maxCode {
+			maxCode = code
+		}
+	}
+
+	for _, label := range missing {
+		maxCode++
+		values[label] = maxCode
+	}
+
+	return values
+}
+
+// enumColumnType wraps the enum type into the Nullable and Arrays of the column
+func enumColumnType(chCol config.ChColumn, enumType string) string {
+	colType := enumType
+	if chCol.IsNullable {
+		colType = fmt.Sprintf("Nullable(%s)", colType)
+	}
+	for i := 0; i < chCol.ArrayDepth; i++ {
+		colType = fmt.Sprintf("Array(%s)", colType)
+	}
+
+	return colType
+}
+
+// enumAlterQueries returns the queries changing the column type in the main, distributed and buffer tables;
+// buffer table is altered on the cluster only if it is created there, i.e. the rows are written to every shard
+func (r *Replicator) enumAlterQueries(cfg *config.Table, colName, colType string) []string {
+	queries := make([]string, 0, 3)
+	for _, chTblName := range []string{cfg.ChMainTable, cfg.DistributedTable, cfg.ChBufferTable} {
+		if chTblName == "" {
+			continue
+		}
+
+		onCluster := ""
+		if r.cfg.ClickHouse.Cluster != "" && (chTblName != cfg.ChBufferTable || cfg.ShardWrites) {
+			onCluster = fmt.Sprintf(" ON CLUSTER %s", r.cfg.ClickHouse.Cluster)
+		}
+
+		queries = append(queries, fmt.Sprintf("ALTER TABLE %s%s MODIFY COLUMN %s %s", chTblName, onCluster, colName, colType))
+	}
+
+	return queries
+}
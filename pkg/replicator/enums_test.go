@@ -0,0 +1,118 @@
+package replicator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+)
+
+func TestMissingEnumLabels(t *testing.T) {
+	tests := []struct {
+		pgValues map[string]int
+		chValues map[string]int
+		expected []string
+	}{
+		{
+			pgValues: map[string]int{"new": 1, "paid": 2, "shipped": 3},
+			chValues: map[string]int{"new": 1, "paid": 2, "shipped": 3},
+			expected: []string{},
+		},
+		{
+			pgValues: map[string]int{"new": 1, "shipped": 4, "paid": 2, "refunded": 3},
+			chValues: map[string]int{"new": 1, "paid": 2},
+			expected: []string{"refunded", "shipped"},
+		},
+		{
+			pgValues: map[string]int{"new": 1},
+			chValues: map[string]int{"new": 1, "obsolete": 2},
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		if res := missingEnumLabels(tt.pgValues, tt.chValues); !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("missingEnumLabels(%v, %v) = %v, expected %v", tt.pgValues, tt.chValues, res, tt.expected)
+		}
+	}
+}
+
+func TestAppendEnumLabels(t *testing.T) {
+	chValues := map[string]int{"new": 1, "paid": 5}
+	expected := map[string]int{"new": 1, "paid": 5, "refunded": 6, "shipped": 7}
+
+	if res := appendEnumLabels(chValues, []string{"refunded", "shipped"}); !reflect.DeepEqual(res, expected) {
+		t.Errorf("appendEnumLabels() = %v, expected %v", res, expected)
+	}
+	if len(chValues) != 2 {
+		t.Errorf("appendEnumLabels() modified clickhouse values: %v", chValues)
+	}
+}
+
+func TestEnumColumnType(t *testing.T) {
+	tests := []struct {
+		chCol    config.ChColumn
+		expected string
+	}{
+		{chCol: config.ChColumn{}, expected: "Enum8('a' = 1)"},
+		{chCol: config.ChColumn{Column: config.Column{IsNullable: true}}, expected: "Nullable(Enum8('a' = 1))"},
+		{chCol: config.ChColumn{Column: config.Column{IsNullable: true, IsArray: true, ArrayDepth: 2}},
+			expected: "Array(Array(Nullable(Enum8('a' = 1))))"},
+	}
+
+	for _, tt := range tests {
+		if res := enumColumnType(tt.chCol, "Enum8('a' = 1)"); res != tt.expected {
+			t.Errorf("enumColumnType(%+v) = %q, expected %q", tt.chCol.Column, res, tt.expected)
+		}
+	}
+}
+
+func TestEnumAlterQueries(t *testing.T) {
+	tests := []struct {
+		cluster     string
+		distributed string
+		shardWrites bool
+		expected    []string
+	}{
+		{
+			expected: []string{
+				"ALTER TABLE orders MODIFY COLUMN status Enum8('a' = 1)",
+				"ALTER TABLE orders_buf MODIFY COLUMN status Enum8('a' = 1)",
+			},
+		},
+		{
+			cluster:     "main",
+			distributed: "orders_all",
+			expected: []string{
+				"ALTER TABLE orders ON CLUSTER main MODIFY COLUMN status Enum8('a' = 1)",
+				"ALTER TABLE orders_all ON CLUSTER main MODIFY COLUMN status Enum8('a' = 1)",
+				"ALTER TABLE orders_buf MODIFY COLUMN status Enum8('a' = 1)",
+			},
+		},
+		{
+			cluster:     "main",
+			shardWrites: true,
+			expected: []string{
+				"ALTER TABLE orders ON CLUSTER main MODIFY COLUMN status Enum8('a' = 1)",
+				"ALTER TABLE orders_buf ON CLUSTER main MODIFY COLUMN status Enum8('a' = 1)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		r := &Replicator{}
+		r.cfg.ClickHouse.Cluster = tt.cluster
+		cfg := &config.Table{
+			ChMainTable:      "orders",
+			ChBufferTable:    "orders_buf",
+			DistributedTable: tt.distributed,
+			ShardWrites:      tt.shardWrites,
+		}
+
+		res := r.enumAlterQueries(cfg, "status", "Enum8('a' = 1)")
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("enumAlterQueries(cluster: %q, shard writes: %t) = %q, expected %q",
+				tt.cluster, tt.shardWrites, res, tt.expected)
+		}
+	}
+}
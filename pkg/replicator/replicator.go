@@ -7,14 +7,15 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go"
 	"github.com/jackc/pgx"
-	"github.com/kshvakov/clickhouse"
 	"github.com/peterbourgon/diskv"
 
 	"github.com/mkabilov/pg2ch/pkg/config"
@@ -22,6 +23,7 @@ import (
 	"github.com/mkabilov/pg2ch/pkg/message"
 	"github.com/mkabilov/pg2ch/pkg/tableengines"
 	"github.com/mkabilov/pg2ch/pkg/utils"
+	"github.com/mkabilov/pg2ch/pkg/utils/chutils"
 	"github.com/mkabilov/pg2ch/pkg/utils/tableinfo"
 )
 
@@ -36,10 +38,13 @@ type clickHouseTable interface {
 	Update(lsn utils.LSN, old message.Row, new message.Row) (mergeIsNeeded bool, err error)
 	Delete(lsn utils.LSN, old message.Row) (mergeIsNeeded bool, err error)
 	SetTupleColumns([]message.Column)
+	SetColumnType(colName string, pgType config.PgColumn)
 	Truncate() error
 	Sync(*pgx.Tx) error
 	Init() error
 	FlushToMainTable() error
+	SetShards(conns []*sql.DB, weights []uint64)
+	SetTransaction(begin message.Begin)
 }
 
 type Replicator struct {
@@ -49,14 +54,19 @@ type Replicator struct {
 	cfg      config.Config
 	errCh    chan error
 
-	pgConn *pgx.Conn
-	chConn *sql.DB
+	pgConn   *pgx.Conn
+	typeConn *pgx.Conn // regular connection resolving the user types of the relation messages, nil until needed
+	chConn   *sql.DB
+	chShards []*sql.DB // connections to the shards of the cluster, used by the tables written to the shards directly
+
+	chShardWeights []uint64 // weights of the shards of the cluster
 
 	persStorage *diskv.Diskv
 
 	chTables     map[config.PgTableName]clickHouseTable
 	oidName      map[utils.OID]config.PgTableName
 	tempSlotName string
+	userTypes    map[utils.OID]struct{} // types announced by the type messages, i.e. the non built-in ones
 
 	finalLSN utils.LSN
 	tableLSN map[config.PgTableName]utils.LSN
@@ -81,6 +91,7 @@ func New(cfg config.Config) *Replicator {
 		tablesToMerge:      make(map[config.PgTableName]struct{}),
 		inTxTables:         make(map[config.PgTableName]struct{}),
 		tableLSN:           make(map[config.PgTableName]utils.LSN),
+		userTypes:          make(map[utils.OID]struct{}),
 	}
 	r.ctx, r.cancel = context.WithCancel(context.Background())
 
@@ -101,8 +112,30 @@ func (r *Replicator) newTable(tblName config.PgTableName, tblConfig config.Table
 		}
 
 		return tableengines.NewCollapsingMergeTree(r.ctx, r.chConn, tblConfig, &r.generationID), nil
+	case config.VersionedCollapsingMergeTree:
+		if tblConfig.SignColumn == "" || tblConfig.VerColumn == "" {
+			return nil, fmt.Errorf("VersionedCollapsingMergeTree requires both sign and version columns to be set")
+		}
+
+		return tableengines.NewVersionedCollapsingMergeTree(r.ctx, r.chConn, tblConfig, &r.generationID), nil
+	case config.AggregatingMergeTree:
+		// the driver can't write the sum states, they are aggregated from the buffer table by the flush
+		if tblConfig.ChBufferTable == "" || tblConfig.InitSyncSkipBufferTable {
+			return nil, fmt.Errorf("AggregatingMergeTree requires buffer table to be used")
+		}
+		fallthrough
+	case config.SummingMergeTree:
+		return tableengines.NewSummingMergeTree(r.ctx, r.chConn, tblConfig, &r.generationID), nil
 	case config.MergeTree:
 		return tableengines.NewMergeTree(r.ctx, r.chConn, tblConfig, &r.generationID), nil
+	case config.ChangeLog:
+		return tableengines.NewChangeLog(r.ctx, r.chConn, tblConfig, &r.generationID), nil
+	case config.SCD2:
+		if tblConfig.SignColumn == "" {
+			return nil, fmt.Errorf("SCD2 requires sign column to be set")
+		}
+
+		return tableengines.NewSCD2(r.ctx, r.chConn, tblConfig, &r.generationID), nil
 	}
 
 	return nil, fmt.Errorf("%s table engine is not implemented", tblConfig.Engine)
@@ -134,7 +167,7 @@ func (r *Replicator) checkPgSlotAndPub(tx *pgx.Tx) error {
 	}
 
 	if errMsg != "" {
-		return fmt.Errorf(errMsg)
+		return fmt.Errorf("%s", errMsg)
 	}
 
 	return nil
@@ -169,6 +202,7 @@ func (r *Replicator) initAndSyncTables() error {
 		if err != nil {
 			return fmt.Errorf("could not instantiate table: %v", err)
 		}
+		r.setShards(tbl, tblConfig)
 
 		if err := tbl.Init(); err != nil {
 			return fmt.Errorf("could not init %s: %v", tblName.String(), err)
@@ -231,7 +265,7 @@ func (r *Replicator) readPersStorage() error {
 		}
 		val, err := r.persStorage.Read(key)
 		if err != nil {
-			return fmt.Errorf("could not read %v key: %v", err)
+			return fmt.Errorf("could not read %v key: %v", key, err)
 		}
 
 		tblName := &config.PgTableName{}
@@ -280,6 +314,7 @@ func (r *Replicator) initTables(tx *pgx.Tx) error {
 		if err != nil {
 			return fmt.Errorf("could not instantiate table: %v", err)
 		}
+		r.setShards(tbl, tblConfig)
 
 		if err := tbl.Init(); err != nil {
 			return fmt.Errorf("could not init %s: %v", tblName.String(), err)
@@ -491,8 +526,13 @@ func (r *Replicator) fetchPgTablesInfo(tx *pgx.Tx) error {
 
 		fqName := config.PgTableName{SchemaName: schemaName, TableName: tableName}
 
-		if _, ok := r.cfg.Tables[fqName]; ok && replicaIdentity != message.ReplicaIdentityFull {
-			return fmt.Errorf("table %s must have FULL replica identity(currently it is %q)", tableName, replicaIdentity)
+		// ReplacingMergeTree needs the key of the old row only, the other engines get it from the row image store
+		if tblCfg, ok := r.cfg.Tables[fqName]; ok && replicaIdentity != message.ReplicaIdentityFull &&
+			(tblCfg.Engine == config.CollapsingMergeTree || tblCfg.Engine.IsDelta() ||
+				tblCfg.Engine == config.ChangeLog) &&
+			!tblCfg.RowImageStore {
+			return fmt.Errorf("table %s must have FULL replica identity(currently it is %q) or use row image store",
+				tableName, replicaIdentity)
 		}
 
 		r.oidName[oid] = fqName
@@ -516,13 +556,19 @@ func (r *Replicator) chConnect() error {
 		return fmt.Errorf("could not ping: %v", err)
 	}
 
-	return nil
+	return r.connectShards()
 }
 
 func (r *Replicator) chDisconnect() {
 	if err := r.chConn.Close(); err != nil {
 		log.Printf("could not close connection to clickhouse: %v", err)
 	}
+
+	for _, conn := range r.chShards {
+		if err := conn.Close(); err != nil {
+			log.Printf("could not close connection to clickhouse shard: %v", err)
+		}
+	}
 }
 
 func (r *Replicator) pgConnect() error {
@@ -548,6 +594,27 @@ func (r *Replicator) pgDisconnect() {
 	if err := r.pgConn.Close(); err != nil {
 		log.Printf("could not close connection to postgresql: %v", err)
 	}
+
+	if r.typeConn != nil {
+		if err := r.typeConn.Close(); err != nil {
+			log.Printf("could not close connection to postgresql: %v", err)
+		}
+	}
+}
+
+// resolvePgType describes the user type of the relation message column; the catalog is not queried
+// by the replication connection while streaming, the separate regular connection is used instead
+func (r *Replicator) resolvePgType(typeOID utils.OID, typMod int32) (config.PgColumn, error) {
+	if r.typeConn == nil || !r.typeConn.IsAlive() {
+		conn, err := pgx.Connect(r.cfg.Postgres.Merge(pgx.ConnConfig{
+			RuntimeParams: map[string]string{"application_name": applicationName}}))
+		if err != nil {
+			return config.PgColumn{}, fmt.Errorf("could not connect to pg: %v", err)
+		}
+		r.typeConn = conn
+	}
+
+	return tableinfo.PgType(r.typeConn, typeOID, typMod)
 }
 
 func (r *Replicator) pgDropRepSlot(tx *pgx.Tx) error {
@@ -673,6 +740,9 @@ func (r *Replicator) HandleMessage(lsn utils.LSN, msg message.Message) error {
 		r.finalLSN = v.FinalLSN
 		r.curTxMergeIsNeeded = false
 		r.isEmptyTx = true
+		for _, chTbl := range r.chTables {
+			chTbl.SetTransaction(v)
+		}
 	case message.Commit:
 		if r.curTxMergeIsNeeded {
 			if err := r.mergeTables(); err != nil {
@@ -686,6 +756,8 @@ func (r *Replicator) HandleMessage(lsn utils.LSN, msg message.Message) error {
 		}
 		r.inTxTables = make(map[config.PgTableName]struct{})
 		r.inTx = false
+	case message.Type:
+		r.userTypes[v.OID] = struct{}{}
 	case message.Relation:
 		_, chTbl := r.getTable(v.OID)
 		if chTbl == nil {
@@ -693,6 +765,19 @@ func (r *Replicator) HandleMessage(lsn utils.LSN, msg message.Message) error {
 		}
 
 		chTbl.SetTupleColumns(v.Columns)
+
+		// domains and composite types might have been altered, so their definitions are fetched again
+		for _, col := range v.Columns {
+			if _, ok := r.userTypes[col.TypeOID]; !ok {
+				continue
+			}
+
+			pgType, err := r.resolvePgType(col.TypeOID, col.Mode)
+			if err != nil {
+				return fmt.Errorf("could not resolve type of the %q column: %v", col.Name, err)
+			}
+			chTbl.SetColumnType(col.Name, setTimeZone(pgType, r.cfg.Postgres.Location))
+		}
 	case message.Insert:
 		tblName, chTbl := r.getTable(v.RelationOID)
 		if chTbl == nil || r.skipTableMessage(tblName) {
@@ -753,7 +838,7 @@ func (r *Replicator) fetchTableConfig(tx *pgx.Tx, tblName config.PgTableName) (c
 	var err error
 	cfg := r.cfg.Tables[tblName]
 
-	cfg.TupleColumns, cfg.PgColumns, err = tableinfo.TablePgColumns(tx, tblName)
+	cfg.TupleColumns, cfg.PgColumns, err = r.tablePgColumns(tx, tblName)
 	if err != nil {
 		return cfg, fmt.Errorf("could not get columns for %s postgres table: %v", tblName.String(), err)
 	}
@@ -763,17 +848,65 @@ func (r *Replicator) fetchTableConfig(tx *pgx.Tx, tblName config.PgTableName) (c
 		return cfg, fmt.Errorf("could not get columns for %q clickhouse table: %v", cfg.ChMainTable, err)
 	}
 
+	if cfg.Engine == config.ChangeLog {
+		if chColumns, err = changeLogColumns(chColumns); err != nil {
+			return cfg, fmt.Errorf("invalid %q clickhouse table: %v", cfg.ChMainTable, err)
+		}
+	}
+
+	cfg.ChColumns = chColumns
 	cfg.ColumnMapping = make(map[string]config.ChColumn)
 	if len(cfg.Columns) > 0 {
-		for pgCol, chCol := range cfg.Columns {
-			if chColCfg, ok := chColumns[chCol]; !ok {
-				return cfg, fmt.Errorf("could not find %q column in %q clickhouse table", chCol, cfg.ChMainTable)
-			} else {
-				cfg.ColumnMapping[pgCol] = chColCfg
+		for pgCol, colCfg := range cfg.Columns {
+			if colCfg.Convert != "" {
+				if colCfg.Target == "" {
+					return cfg, fmt.Errorf("converter is set for %q column, which has no target column", pgCol)
+				}
+
+				if _, ok := tableengines.LookupConverter(colCfg.Convert); !ok {
+					return cfg, fmt.Errorf("unknown converter %q of %q column", colCfg.Convert, pgCol)
+				}
+			}
+
+			if colCfg.Target != "" {
+				if chColCfg, ok := chColumns[colCfg.Target]; !ok {
+					return cfg, fmt.Errorf("could not find %q column in %q clickhouse table", colCfg.Target, cfg.ChMainTable)
+				} else {
+					cfg.ColumnMapping[pgCol] = chColCfg
+				}
+			}
+
+			if colCfg.FieldsPrefix != "" {
+				pgColumn := cfg.PgColumns[pgCol]
+				if len(pgColumn.Fields) == 0 || pgColumn.IsArray {
+					return cfg, fmt.Errorf("%q column is not of composite type", pgCol)
+				}
+
+				colCfg.FieldColumns = make([]string, len(pgColumn.Fields))
+				for i, field := range pgColumn.Fields {
+					colCfg.FieldColumns[i] = colCfg.FieldsPrefix + field.Name
+				}
+				cfg.Columns[pgCol] = colCfg
+			}
+
+			for _, chColName := range colCfg.CompanionColumns() {
+				if _, ok := chColumns[chColName]; !ok {
+					return cfg, fmt.Errorf("could not find %q column in %q clickhouse table", chColName, cfg.ChMainTable)
+				}
+			}
+
+			if colCfg.LowerColumn != "" || colCfg.UpperColumn != "" || colCfg.BoundsColumn != "" {
+				if _, ok := utils.RangeSubtypes[cfg.PgColumns[pgCol].BaseType]; !ok {
+					return cfg, fmt.Errorf("%q column is not of range type", pgCol)
+				}
 			}
 		}
 	} else {
 		for _, pgCol := range cfg.TupleColumns {
+			if cfg.Engine.IsDelta() && !isDeltaColumn(cfg, pgCol.Name) {
+				continue
+			}
+
 			if chColCfg, ok := chColumns[pgCol.Name]; !ok {
 				return cfg, fmt.Errorf("could not find %q column in %q clickhouse table", pgCol.Name, cfg.ChMainTable)
 			} else {
@@ -782,5 +915,251 @@ func (r *Replicator) fetchTableConfig(tx *pgx.Tx, tblName config.PgTableName) (c
 		}
 	}
 
+	if err := setJSONExtractions(&cfg, chColumns); err != nil {
+		return cfg, err
+	}
+
+	if err := checkChColumnTypes(cfg); err != nil {
+		return cfg, err
+	}
+
+	if err := r.checkEnums(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Engine.IsDelta() {
+		if err := checkDeltaColumns(cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	cfg.Cluster = r.cfg.ClickHouse.Cluster
+	if cfg.ShardWrites {
+		if err := checkShardColumns(&cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	// versions of the rows to be cancelled are kept in the row image store
+	if (cfg.Engine == config.VersionedCollapsingMergeTree || cfg.Engine == config.SCD2) && !cfg.RowImageStore {
+		return cfg, fmt.Errorf("%s engine requires row image store", cfg.Engine)
+	}
+
+	if cfg.RowImageStore {
+		if err := checkRowImageKey(cfg); err != nil {
+			return cfg, err
+		}
+		cfg.RowImageStorePath = filepath.Join(r.cfg.RowStorePath, tblName.String())
+	}
+
 	return cfg, nil
 }
+
+// changeLogColumns returns the columns of the change log table the pairs of the old_ and new_ prefixed columns
+// the values of the rows are stored in, by the names without the prefix
+func changeLogColumns(chColumns map[string]config.ChColumn) (map[string]config.ChColumn, error) {
+	res := make(map[string]config.ChColumn)
+	for name, chCol := range chColumns {
+		if !strings.HasPrefix(name, "new_") {
+			continue
+		}
+		name = strings.TrimPrefix(name, "new_")
+
+		oldCol, ok := chColumns["old_"+name]
+		if !ok {
+			return nil, fmt.Errorf("%q column has no old_%s pair", chCol.Name, name)
+		}
+
+		// nulls tell the values missing for the kind of the change from the default ones
+		for _, col := range []config.ChColumn{chCol, oldCol} {
+			if !col.IsNullable && !col.IsArray {
+				return nil, fmt.Errorf("%q column must be Nullable", col.Name)
+			}
+		}
+
+		chCol.Name = name
+		res[name] = chCol
+	}
+
+	return res, nil
+}
+
+// checkChColumnTypes checks that the driver can write into all the clickhouse columns the table is replicated into
+func checkChColumnTypes(cfg config.Table) error {
+	chColumns := make([]config.ChColumn, 0, len(cfg.ColumnMapping))
+	for _, chCol := range cfg.ColumnMapping {
+		chColumns = append(chColumns, chCol)
+	}
+	for _, colCfg := range cfg.Columns {
+		for _, chColName := range colCfg.CompanionColumns() {
+			chColumns = append(chColumns, cfg.ChColumns[chColName])
+		}
+	}
+	for _, extraction := range cfg.JSONExtract {
+		chColumns = append(chColumns, extraction.ChColumn)
+	}
+
+	for _, chCol := range chColumns {
+		if err := chutils.CheckWritable(chCol.Column); err != nil {
+			return fmt.Errorf("%q clickhouse column: %v", chCol.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkRowImageKey checks that the replica identity columns the row images are stored by are replicated
+func checkRowImageKey(cfg config.Table) error {
+	keyColumns := 0
+	for _, col := range cfg.TupleColumns {
+		if !col.IsKey {
+			continue
+		}
+		keyColumns++
+
+		// the delta engines don't look the rows up in clickhouse, so their key columns might be not replicated
+		if _, ok := cfg.ColumnMapping[col.Name]; !ok && !cfg.Engine.IsDelta() {
+			return fmt.Errorf("replica identity column %q must be replicated to use row image store", col.Name)
+		}
+	}
+
+	if keyColumns == 0 {
+		return fmt.Errorf("table has no replica identity, which is required by row image store")
+	}
+
+	return nil
+}
+
+// checkDeltaColumns checks that the dimensions and measures of the delta engine table are replicated,
+// the measures are numeric and no other columns are replicated
+func checkDeltaColumns(cfg config.Table) error {
+	if len(cfg.JSONExtract) > 0 {
+		return fmt.Errorf("json extractions are not supported by the %s engine", cfg.Engine)
+	}
+
+	for _, pgCol := range cfg.Measures {
+		pgColumn, ok := cfg.PgColumns[pgCol]
+		if !ok {
+			return fmt.Errorf("could not find %q measure column in postgres table", pgCol)
+		}
+
+		if _, err := chutils.MeasureType(pgColumn); err != nil {
+			return fmt.Errorf("%q measure column is not numeric: %v", pgCol, err)
+		}
+
+		chCol, ok := cfg.ColumnMapping[pgCol]
+		if !ok {
+			return fmt.Errorf("%q measure column must be replicated", pgCol)
+		}
+
+		if chCol.IsArray || chCol.IsNullable || !isSignedNumber(chCol.BaseType) {
+			return fmt.Errorf("%q measure column must be replicated into signed integer, float or decimal column",
+				pgCol)
+		}
+	}
+
+	for _, pgCol := range cfg.Dimensions {
+		if _, ok := cfg.PgColumns[pgCol]; !ok {
+			return fmt.Errorf("could not find %q dimension column in postgres table", pgCol)
+		}
+
+		if _, ok := cfg.ColumnMapping[pgCol]; !ok {
+			return fmt.Errorf("%q dimension column must be replicated", pgCol)
+		}
+	}
+
+	for pgCol := range cfg.ColumnMapping {
+		if !isDeltaColumn(cfg, pgCol) {
+			return fmt.Errorf("%q column is neither a dimension nor a measure", pgCol)
+		}
+	}
+
+	for pgCol, colCfg := range cfg.Columns {
+		if len(colCfg.CompanionColumns()) > 0 && !isDeltaColumn(cfg, pgCol) {
+			return fmt.Errorf("%q column is neither a dimension nor a measure", pgCol)
+		}
+	}
+
+	return nil
+}
+
+// isDeltaColumn checks if the column is either a dimension or a measure of the table
+func isDeltaColumn(cfg config.Table, pgCol string) bool {
+	for _, name := range cfg.Dimensions {
+		if name == pgCol {
+			return true
+		}
+	}
+
+	for _, name := range cfg.Measures {
+		if name == pgCol {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSignedNumber checks if the clickhouse type can hold negative deltas
+func isSignedNumber(chType string) bool {
+	switch chType {
+	case utils.ChInt8, utils.ChInt16, utils.ChInt32, utils.ChInt64, utils.ChFloat32, utils.ChFloat64:
+		return true
+	}
+
+	return strings.HasPrefix(chType, utils.ChDecimal)
+}
+
+// setJSONExtractions checks the json extractions of the table and sets the clickhouse columns they are extracted into
+func setJSONExtractions(cfg *config.Table, chColumns map[string]config.ChColumn) error {
+	extractions := make([]config.JSONExtraction, len(cfg.JSONExtract))
+	for i, extraction := range cfg.JSONExtract {
+		pgCol, ok := cfg.PgColumns[extraction.PgColumn]
+		if !ok {
+			return fmt.Errorf("could not find %q column in postgres table", extraction.PgColumn)
+		}
+
+		if (pgCol.BaseType != utils.PgJson && pgCol.BaseType != utils.PgJsonb) || pgCol.IsArray {
+			return fmt.Errorf("could not extract %q: %q column is not of json or jsonb type",
+				extraction.Path, extraction.PgColumn)
+		}
+
+		if extraction.ChColumn, ok = chColumns[extraction.Column]; !ok {
+			return fmt.Errorf("could not find %q column in %q clickhouse table", extraction.Column, cfg.ChMainTable)
+		}
+
+		extractions[i] = extraction
+	}
+	cfg.JSONExtract = extractions
+
+	return nil
+}
+
+func (r *Replicator) tablePgColumns(tx *pgx.Tx, tblName config.PgTableName) ([]message.Column, map[string]config.PgColumn, error) {
+	tupleColumns, pgColumns, err := tableinfo.TablePgColumns(tx, tblName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for colName, pgCol := range pgColumns {
+		pgColumns[colName] = setTimeZone(pgCol, r.cfg.Postgres.Location)
+	}
+
+	return tupleColumns, pgColumns, nil
+}
+
+// setTimeZone sets timezone of the timestamp without time zone values including the ones in the composite types
+func setTimeZone(pgCol config.PgColumn, loc *time.Location) config.PgColumn {
+	pgCol.TimeZone = loc
+	if len(pgCol.Fields) == 0 {
+		return pgCol
+	}
+
+	fields := make([]config.PgField, len(pgCol.Fields))
+	for i, field := range pgCol.Fields {
+		fields[i] = config.PgField{PgColumn: setTimeZone(field.PgColumn, loc), Name: field.Name}
+	}
+	pgCol.Fields = fields
+
+	return pgCol
+}
@@ -0,0 +1,132 @@
+package replicator
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// types of the sharding columns the rows are routed by the same way as by the distributed table
+var shardKeyTypes = map[string]struct{}{
+	utils.ChInt8:        {},
+	utils.ChInt16:       {},
+	utils.ChInt32:       {},
+	utils.ChInt64:       {},
+	utils.ChUInt8:       {},
+	utils.ChUInt16:      {},
+	utils.ChUint32:      {},
+	utils.ChUint64:      {},
+	utils.ChFloat32:     {},
+	utils.ChFloat64:     {},
+	utils.ChString:      {},
+	utils.ChFixedString: {},
+	utils.ChDate:        {},
+	utils.ChDateTime:    {},
+}
+
+// connectShards connects to the shards of the cluster if any of the tables is written to the shards directly;
+// replicas of the shard are connected to in order
+func (r *Replicator) connectShards() error {
+	shardWrites := false
+	for _, tblCfg := range r.cfg.Tables {
+		shardWrites = shardWrites || tblCfg.ShardWrites
+	}
+
+	if !shardWrites {
+		return nil
+	}
+
+	rows, err := r.chConn.Query("SELECT arrayStringConcat(groupArray(concat(host_address, ':', toString(port))), ','), "+
+		"any(shard_weight) FROM (SELECT * FROM system.clusters WHERE cluster = ? ORDER BY shard_num, replica_num) "+
+		"GROUP BY shard_num ORDER BY shard_num", r.cfg.ClickHouse.Cluster)
+	if err != nil {
+		return fmt.Errorf("could not query shards of the %q cluster: %v", r.cfg.ClickHouse.Cluster, err)
+	}
+	defer rows.Close()
+
+	totalWeight := uint64(0)
+	for rows.Next() {
+		var (
+			replicas string
+			weight   uint64
+		)
+
+		if err := rows.Scan(&replicas, &weight); err != nil {
+			return fmt.Errorf("could not scan: %v", err)
+		}
+
+		conn, err := sql.Open("clickhouse", r.cfg.ClickHouse.ShardConnectionString(strings.Split(replicas, ",")))
+		if err != nil {
+			return fmt.Errorf("could not open connection to the shard #%d: %v", len(r.chShards)+1, err)
+		}
+
+		if err := conn.Ping(); err != nil {
+			return fmt.Errorf("could not ping shard #%d: %v", len(r.chShards)+1, err)
+		}
+
+		r.chShards = append(r.chShards, conn)
+		r.chShardWeights = append(r.chShardWeights, weight)
+		totalWeight += weight
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(r.chShards) == 0 {
+		return fmt.Errorf("could not find %q cluster", r.cfg.ClickHouse.Cluster)
+	}
+
+	if totalWeight == 0 {
+		return fmt.Errorf("all the shards of the %q cluster have zero weight", r.cfg.ClickHouse.Cluster)
+	}
+
+	return nil
+}
+
+// setShards passes the connections to the shards to the table written to the shards directly
+func (r *Replicator) setShards(tbl clickHouseTable, tblCfg config.Table) {
+	if tblCfg.ShardWrites {
+		tbl.SetShards(r.chShards, r.chShardWeights)
+	}
+}
+
+// checkShardColumns sets the primary key as the sharding columns if they are not specified,
+// the sharding columns must be replicated into the columns cityHash64 is computed for by pg2ch
+func checkShardColumns(cfg *config.Table) error {
+	if len(cfg.ShardBy) == 0 {
+		pkColumns := make([]string, 0)
+		for pgColName, pgCol := range cfg.PgColumns {
+			if pgCol.PkCol < 1 {
+				continue
+			}
+
+			for len(pkColumns) < pgCol.PkCol {
+				pkColumns = append(pkColumns, "")
+			}
+			pkColumns[pgCol.PkCol-1] = pgColName
+		}
+
+		if len(pkColumns) == 0 {
+			return fmt.Errorf("table has no primary key, shard_by must be specified to write to the shards")
+		}
+		cfg.ShardBy = pkColumns
+	}
+
+	for _, pgCol := range cfg.ShardBy {
+		chCol, ok := cfg.ColumnMapping[pgCol]
+		if !ok {
+			return fmt.Errorf("sharding column %q must be replicated", pgCol)
+		}
+
+		if _, ok := shardKeyTypes[chCol.BaseType]; !ok || chCol.IsArray || chCol.IsNullable {
+			return fmt.Errorf("sharding column %q must be of a non-nullable integer, float, string, "+
+				"Date or DateTime type", pgCol)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package tableengines
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// kinds of the changes stored in the change log
+const (
+	changeInsert   = "insert"
+	changeUpdate   = "update"
+	changeDelete   = "delete"
+	changeTruncate = "truncate"
+	changeSnapshot = "snapshot" // row copied by the initial sync
+)
+
+// changeLogTable is the append-only table storing every change of the rows along with the old and new values
+type changeLogTable struct {
+	genericTable
+
+	seq      uint32    // number of the change in the current transaction
+	syncTime time.Time // commit time of the rows copied by the initial sync
+}
+
+// NewChangeLog instantiates changeLogTable
+func NewChangeLog(ctx context.Context, conn *sql.DB, tblCfg config.Table, genID *uint64) *changeLogTable {
+	t := changeLogTable{
+		genericTable: newGenericTable(ctx, conn, tblCfg, genID),
+	}
+
+	columns := []string{tblCfg.OpColumn, tblCfg.LsnColumn, tblCfg.XidColumn, tblCfg.CommitTimeColumn, tblCfg.SeqColumn}
+	for _, prefix := range []string{"old_", "new_"} {
+		for _, chColName := range t.chUsedColumns {
+			columns = append(columns, prefix+chColName)
+		}
+	}
+	t.chUsedColumns = columns
+
+	t.flushQueries = []string{fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[3]s ORDER BY %[4]s",
+		t.mainTable(), strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable, t.cfg.BufferTableRowIdColumn)}
+
+	return &t
+}
+
+// Sync performs initial sync of the data; pgTx is a transaction in which temporary replication slot is created
+func (t *changeLogTable) Sync(pgTx *pgx.Tx) error {
+	t.syncTime = time.Now()
+
+	return t.genSync(pgTx, t)
+}
+
+// Write implements io.Writer which is used during the Sync process, see genSync method
+func (t *changeLogTable) Write(p []byte) (int, error) {
+	var row []interface{}
+
+	row, n, err := t.syncConvertIntoRow(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if row == nil { // skipped according to the null policy
+		return n, nil
+	}
+
+	oldVals, err := t.changeValues(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	res := append([]interface{}{changeSnapshot, uint64(0), uint32(0), t.syncTime, uint32(0)}, oldVals...)
+
+	return n, t.insertRow(append(res, row...))
+}
+
+// SetTransaction sets the transaction the following changes belong to
+func (t *changeLogTable) SetTransaction(begin message.Begin) {
+	t.genericTable.SetTransaction(begin)
+	t.seq = 0
+}
+
+// Insert handles incoming insert DML operation
+func (t *changeLogTable) Insert(lsn utils.LSN, new message.Row) (bool, error) {
+	cmd, err := t.changeCommand(lsn, changeInsert, nil, new)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// Update handles incoming update DML operation, the updates which don't change anything are stored as well
+func (t *changeLogTable) Update(lsn utils.LSN, old, new message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, new)
+	if err != nil {
+		return false, err
+	}
+	new = fillUnchanged(old, new)
+
+	cmd, err := t.changeCommand(lsn, changeUpdate, old, new)
+	if err != nil {
+		return false, err
+	}
+
+	if _, keyChanged := t.compareRows(old, new); keyChanged {
+		if err := t.forgetRow(old); err != nil {
+			return false, err
+		}
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// Delete handles incoming delete DML operation
+func (t *changeLogTable) Delete(lsn utils.LSN, old message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, nil)
+	if err != nil {
+		return false, err
+	}
+
+	cmd, err := t.changeCommand(lsn, changeDelete, old, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.forgetRow(old); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// Truncate stores the truncate change, the history of the rows is kept
+func (t *changeLogTable) Truncate() error {
+	t.resetRowCaches()
+
+	cmd, err := t.changeCommand(t.txBegin.FinalLSN, changeTruncate, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.processCommandSet(commandSet{cmd})
+
+	return err
+}
+
+// changeCommand returns values of the change of the row, either of the old and new rows might be nil
+func (t *changeLogTable) changeCommand(lsn utils.LSN, op string, old, new message.Row) ([]interface{}, error) {
+	oldVals, err := t.changeValues(old)
+	if oldVals == nil || err != nil {
+		return nil, err
+	}
+
+	newVals, err := t.changeValues(new)
+	if newVals == nil || err != nil {
+		return nil, err
+	}
+
+	t.seq++
+	res := []interface{}{op, uint64(lsn), uint32(t.txBegin.XID), t.txBegin.Timestamp, t.seq}
+	res = append(res, oldVals...)
+
+	return append(res, newVals...), nil
+}
+
+// changeValues converts the row, nulls or empty arrays are returned if there's no row
+func (t *changeLogTable) changeValues(row message.Row) ([]interface{}, error) {
+	if row != nil {
+		return t.convertTuples(row)
+	}
+
+	res := make([]interface{}, 0)
+	for _, pgColName := range t.pgUsedColumns {
+		vals, err := t.defaultValues(pgColName)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, vals...)
+	}
+
+	return res, nil
+}
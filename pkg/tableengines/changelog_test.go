@@ -0,0 +1,50 @@
+package tableengines
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func TestChangeLogCommands(t *testing.T) {
+	cfg := testTableConfig()
+	for i := range cfg.TupleColumns { // full replica identity
+		cfg.TupleColumns[i].IsKey = true
+	}
+	cfg.ColumnMapping["id"] = config.ChColumn{Name: "id", Column: config.Column{BaseType: utils.ChInt32, IsNullable: true}}
+	cfg.ColumnMapping["region"] = config.ChColumn{Name: "region",
+		Column: config.Column{BaseType: utils.ChString, IsArray: true, ArrayDepth: 1}}
+	cfg.PgColumns["region"] = config.PgColumn{Column: config.Column{BaseType: utils.PgText, IsArray: true}}
+
+	tbl := NewChangeLog(context.Background(), nil, cfg, new(uint64))
+	commitTime := time.Unix(100, 123456000) // microseconds are kept by the DateTime64(6) column
+	tbl.SetTransaction(message.Begin{XID: 7, Timestamp: commitTime})
+
+	if _, err := tbl.Insert(10, message.Row{text("1"), text("{eu}"), text("5")}); err != nil {
+		t.Fatalf("Insert(): unexpected error: %v", err)
+	}
+
+	if _, err := tbl.Delete(10, message.Row{text("1"), text("{eu}"), nullTuple}); err != nil {
+		t.Fatalf("Delete(): unexpected error: %v", err)
+	}
+
+	expected := [][]interface{}{
+		{changeInsert, uint64(10), uint32(7), commitTime, uint32(1),
+			nil, []string{}, nil,
+			int32(1), []string{"eu"}, int64(5)},
+		{changeDelete, uint64(10), uint32(7), commitTime, uint32(2),
+			int32(1), []string{"eu"}, nil,
+			nil, []string{}, nil},
+	}
+
+	for i, cmd := range expected {
+		if i >= tbl.bufferCmdId || !reflect.DeepEqual(tbl.buffer[i][0].data, cmd) {
+			t.Errorf("change #%d = %#v, expected %#v", i+1, tbl.buffer[i], cmd)
+		}
+	}
+}
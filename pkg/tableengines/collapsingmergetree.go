@@ -28,7 +28,7 @@ func NewCollapsingMergeTree(ctx context.Context, conn *sql.DB, tblCfg config.Tab
 	t.chUsedColumns = append(t.chUsedColumns, tblCfg.SignColumn)
 
 	t.flushQueries = []string{fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[3]s ORDER BY %[4]s",
-		t.cfg.ChMainTable, strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable, t.cfg.BufferTableRowIdColumn)}
+		t.mainTable(), strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable, t.cfg.BufferTableRowIdColumn)}
 
 	return &t
 }
@@ -47,6 +47,10 @@ func (t *collapsingMergeTreeTable) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
+	if row == nil { // skipped according to the null policy
+		return n, nil
+	}
+
 	if t.cfg.GenerationColumn != "" {
 		row = append(row, 0) // generationID
 	}
@@ -57,26 +61,69 @@ func (t *collapsingMergeTreeTable) Write(p []byte) (int, error) {
 
 // Insert handles incoming insert DML operation
 func (t *collapsingMergeTreeTable) Insert(lsn utils.LSN, new message.Row) (bool, error) {
-	return t.processCommandSet(commandSet{
-		append(t.convertTuples(new), 1),
-	})
+	cmd, err := t.tupleCommand(new, 1)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
 }
 
 // Update handles incoming update DML operation
 func (t *collapsingMergeTreeTable) Update(lsn utils.LSN, old, new message.Row) (bool, error) {
-	if equal, _ := t.compareRows(old, new); equal {
+	old, err := t.restoreOldRow(old, new)
+	if err != nil {
+		return false, err
+	}
+	new = fillUnchanged(old, new)
+
+	equal, keyChanged := t.compareRows(old, new)
+	if equal {
 		return t.processCommandSet(nil)
 	}
 
-	return t.processCommandSet(commandSet{
-		append(t.convertTuples(old), -1),
-		append(t.convertTuples(new), 1),
-	})
+	oldCmd, err := t.tupleCommand(old, -1)
+	if err != nil {
+		return false, err
+	}
+
+	newCmd, err := t.tupleCommand(new, 1)
+	if err != nil {
+		return false, err
+	}
+
+	if keyChanged {
+		if err := t.forgetRow(old); err != nil {
+			return false, err
+		}
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{oldCmd, newCmd})
 }
 
 // Delete handles incoming delete DML operation
 func (t *collapsingMergeTreeTable) Delete(lsn utils.LSN, old message.Row) (bool, error) {
-	return t.processCommandSet(commandSet{
-		append(t.convertTuples(old), -1),
-	})
+	old, err := t.restoreOldRow(old, nil)
+	if err != nil {
+		return false, err
+	}
+
+	cmd, err := t.tupleCommand(old, -1)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.forgetRow(old); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
 }
@@ -0,0 +1,81 @@
+package tableengines
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// fieldValues returns values of the clickhouse columns the fields of the composite value are flattened into;
+// columns of the fields missing in the current definition of the type get nulls
+func (t *genericTable) fieldValues(val string, colCfg config.ColumnConfig, pgType config.PgColumn) ([]interface{}, error) {
+	items, err := decodeComposite(val, pgType)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]int, len(pgType.Fields))
+	for i, field := range pgType.Fields {
+		fields[field.Name] = i
+	}
+
+	vals := make([]interface{}, len(colCfg.FieldColumns))
+	for i, chColName := range colCfg.FieldColumns {
+		chCol := t.cfg.ChColumns[chColName]
+		fieldName := strings.TrimPrefix(chColName, colCfg.FieldsPrefix)
+
+		idx, ok := fields[fieldName]
+		if !ok || !items[idx].Valid {
+			if vals[i], err = t.nullField(fieldName, chCol, colCfg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		vals[i], err = convert(items[idx].String, chCol, pgType.Fields[idx].PgColumn, fieldColumnConfig(colCfg))
+		if err != nil {
+			return nil, fmt.Errorf("could not convert %q field: %v", fieldName, err)
+		}
+	}
+
+	return vals, nil
+}
+
+// nullField returns value of the clickhouse column for the null field of the composite value,
+// nulls for the non-nullable columns are handled according to the null policy of the composite column
+func (t *genericTable) nullField(fieldName string, chCol config.ChColumn, colCfg config.ColumnConfig) (interface{}, error) {
+	if chCol.IsNullable {
+		return nil, nil
+	}
+
+	switch t.nullPolicy(colCfg) {
+	case config.NullPolicyDefault:
+		return zeroValue(chCol)
+	case config.NullPolicySkip:
+		return nil, errSkipRow
+	}
+
+	return nil, fmt.Errorf("got null in %q field, which is not nullable on the ClickHouse side", fieldName)
+}
+
+func decodeComposite(val string, pgType config.PgColumn) ([]sql.NullString, error) {
+	items, err := utils.DecodeComposite(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) != len(pgType.Fields) {
+		return nil, fmt.Errorf("composite value has %d fields while %s type has %d",
+			len(items), pgType.BaseType, len(pgType.Fields))
+	}
+
+	return items, nil
+}
+
+// fieldColumnConfig returns settings of the composite column applicable to its fields
+func fieldColumnConfig(colCfg config.ColumnConfig) config.ColumnConfig {
+	return config.ColumnConfig{OutOfRange: colCfg.OutOfRange}
+}
@@ -0,0 +1,58 @@
+package tableengines
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func TestFieldValues(t *testing.T) {
+	pgType := config.PgColumn{
+		Column: config.Column{BaseType: "address"},
+		Fields: []config.PgField{
+			{Name: "city", PgColumn: pgColumn(utils.PgText)},
+			{Name: "zip", PgColumn: pgColumn(utils.PgInteger)},
+		},
+	}
+
+	tbl := &genericTable{cfg: config.Table{ChColumns: map[string]config.ChColumn{
+		"address_city":  {Column: config.Column{BaseType: utils.ChString, IsNullable: true}},
+		"address_zip":   {Column: config.Column{BaseType: utils.ChInt32}},
+		"address_floor": {Column: config.Column{BaseType: utils.ChInt32, IsNullable: true}},
+	}}}
+
+	colCfg := config.ColumnConfig{
+		FieldsPrefix: "address_",
+		FieldColumns: []string{"address_city", "address_zip", "address_floor"},
+	}
+
+	res, err := tbl.fieldValues(`("New York",10001)`, colCfg, pgType)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the floor field is missing in the current definition of the type
+	if expected := []interface{}{"New York", int32(10001), nil}; !reflect.DeepEqual(res, expected) {
+		t.Errorf("fieldValues() = %#v, expected %#v", res, expected)
+	}
+
+	if res, err := tbl.fieldValues(`(,)`, colCfg, pgType); err == nil {
+		t.Errorf("expected error for the null in the non-nullable column, got %#v", res)
+	}
+
+	colCfg.NullPolicy = config.NullPolicyDefault
+	res, err = tbl.fieldValues(`(,)`, colCfg, pgType)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := []interface{}{nil, int32(0), nil}; !reflect.DeepEqual(res, expected) {
+		t.Errorf("fieldValues() = %#v, expected %#v", res, expected)
+	}
+
+	if res, err := tbl.fieldValues(`(a,1,2)`, colCfg, pgType); err == nil {
+		t.Errorf("expected error for the value with extra fields, got %#v", res)
+	}
+}
@@ -0,0 +1,877 @@
+package tableengines
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+const (
+	pgTrue  = "t"
+	pgFalse = "f"
+
+	defaultDateTime64Precision = 3
+	maxQuotedValueLength       = 100
+)
+
+// go types used for the elements of the clickhouse arrays
+var chGoTypes = map[string]reflect.Type{
+	utils.ChInt8:        reflect.TypeOf(int8(0)),
+	utils.ChInt16:       reflect.TypeOf(int16(0)),
+	utils.ChInt32:       reflect.TypeOf(int32(0)),
+	utils.ChInt64:       reflect.TypeOf(int64(0)),
+	utils.ChUInt8:       reflect.TypeOf(uint8(0)),
+	utils.ChUInt16:      reflect.TypeOf(uint16(0)),
+	utils.ChUint32:      reflect.TypeOf(uint32(0)),
+	utils.ChUint64:      reflect.TypeOf(uint64(0)),
+	utils.ChFloat32:     reflect.TypeOf(float32(0)),
+	utils.ChFloat64:     reflect.TypeOf(float64(0)),
+	utils.ChFixedString: reflect.TypeOf(""),
+	utils.ChString:      reflect.TypeOf(""),
+	utils.ChUUID:        reflect.TypeOf(""),
+	utils.ChEnum8:       reflect.TypeOf(""),
+	utils.ChEnum16:      reflect.TypeOf(""),
+	utils.ChIPv4:        reflect.TypeOf(net.IP{}),
+	utils.ChIPv6:        reflect.TypeOf(net.IP{}),
+	utils.ChDate:        reflect.TypeOf(time.Time{}),
+	utils.ChDateTime:    reflect.TypeOf(time.Time{}),
+	utils.ChDateTime64:  reflect.TypeOf(time.Time{}),
+}
+
+// convertColumn converts value of the postgresql column into the values of the clickhouse columns it is mapped to
+func (t *genericTable) convertColumn(pgColName string, val string) ([]interface{}, error) {
+	vals := make([]interface{}, 0, 1)
+
+	colCfg := t.cfg.Columns[pgColName]
+	if colCfg.OutOfRange == "" {
+		colCfg.OutOfRange = t.cfg.OutOfRange
+	}
+
+	if chCol, ok := t.columnMapping[pgColName]; ok {
+		var (
+			res interface{}
+			err error
+		)
+
+		if converter, ok := t.converters[pgColName]; ok {
+			res, err = converter(val, chCol, t.cfg.PgColumns[pgColName])
+		} else {
+			res, err = convert(val, chCol, t.cfg.PgColumns[pgColName], colCfg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not convert %q value of the %q column into %s: %v",
+				shortValue(val), pgColName, chCol.BaseType, err)
+		}
+		vals = append(vals, res)
+	}
+
+	if len(colCfg.CompanionColumns()) > 0 {
+		companions, err := t.companionValues(val, colCfg, t.cfg.PgColumns[pgColName])
+		if err == errSkipRow {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("could not convert %q value of the %q column: %v", shortValue(val), pgColName, err)
+		}
+		vals = append(vals, companions...)
+	}
+
+	if extractions := t.jsonExtractions[pgColName]; len(extractions) > 0 {
+		extracted, err := extractJSON(val, extractions)
+		if err != nil {
+			return nil, fmt.Errorf("could not extract values of the %q column from %q: %v",
+				pgColName, shortValue(val), err)
+		}
+		vals = append(vals, extracted...)
+	}
+
+	return vals, nil
+}
+
+// shortValue truncates the value quoted in the error messages
+func shortValue(val string) string {
+	if len(val) <= maxQuotedValueLength {
+		return val
+	}
+
+	return val[:maxQuotedValueLength] + "..."
+}
+
+// nullColumn returns values of the clickhouse columns the postgresql column holding null is mapped to;
+// nulls for the non-nullable columns are handled according to the null policy, errSkipRow means the row is to be skipped
+func (t *genericTable) nullColumn(pgColName string) ([]interface{}, error) {
+	colCfg := t.cfg.Columns[pgColName]
+
+	if hasNonNullable(t.mappedChColumns(pgColName)) {
+		switch t.nullPolicy(colCfg) {
+		case config.NullPolicyDefault:
+		case config.NullPolicyLiteral:
+			return t.convertColumn(pgColName, colCfg.NullLiteral)
+		case config.NullPolicySkip:
+			return nil, errSkipRow
+		default:
+			return nil, fmt.Errorf("got null in %s field, which is not nullable on the ClickHouse side", pgColName)
+		}
+	}
+
+	return t.defaultValues(pgColName)
+}
+
+// defaultValues returns nulls or the default values of the clickhouse columns the postgresql column is mapped to
+func (t *genericTable) defaultValues(pgColName string) ([]interface{}, error) {
+	chCols := t.mappedChColumns(pgColName)
+
+	vals := make([]interface{}, 0, len(chCols))
+	for _, chCol := range chCols {
+		if chCol.IsNullable {
+			vals = append(vals, nil)
+			continue
+		}
+
+		val, err := zeroValue(chCol)
+		if err != nil {
+			return nil, fmt.Errorf("could not get default value of the %q field: %v", pgColName, err)
+		}
+		vals = append(vals, val)
+	}
+
+	if extractions := t.jsonExtractions[pgColName]; len(extractions) > 0 {
+		extracted, err := nullJSONExtractions(extractions)
+		if err != nil {
+			return nil, fmt.Errorf("could not extract values of the %q field: %v", pgColName, err)
+		}
+		vals = append(vals, extracted...)
+	}
+
+	return vals, nil
+}
+
+// mappedChColumns returns the target and companion clickhouse columns of the postgresql column
+func (t *genericTable) mappedChColumns(pgColName string) []config.ChColumn {
+	chCols := make([]config.ChColumn, 0, 1)
+	if chCol, ok := t.columnMapping[pgColName]; ok {
+		chCols = append(chCols, chCol)
+	}
+	for _, chColName := range t.cfg.Columns[pgColName].CompanionColumns() {
+		chCols = append(chCols, t.cfg.ChColumns[chColName])
+	}
+
+	return chCols
+}
+
+// hasNonNullable checks if any of the columns can't hold nulls
+func hasNonNullable(chCols []config.ChColumn) bool {
+	for _, chCol := range chCols {
+		if !chCol.IsNullable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nullPolicy returns null policy of the column, which falls back to the table one
+func (t *genericTable) nullPolicy(colCfg config.ColumnConfig) string {
+	if colCfg.NullPolicy != "" {
+		return colCfg.NullPolicy
+	}
+
+	return t.cfg.NullPolicy
+}
+
+// companionValues returns values of the additional clickhouse columns in the order of ColumnConfig.CompanionColumns
+func (t *genericTable) companionValues(val string, colCfg config.ColumnConfig,
+	pgType config.PgColumn) ([]interface{}, error) {
+	vals := make([]interface{}, 0)
+
+	if colCfg.PrefixColumn != "" {
+		_, prefix, err := utils.ParseInet(val)
+		if err != nil {
+			return nil, fmt.Errorf("could not get network prefix length: %v", err)
+		}
+		vals = append(vals, uint8(prefix))
+	}
+
+	if colCfg.LowerColumn != "" || colCfg.UpperColumn != "" || colCfg.BoundsColumn != "" {
+		rangeVals, err := t.rangeValues(val, colCfg, pgType)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, rangeVals...)
+	}
+
+	if len(colCfg.FieldColumns) > 0 {
+		fieldVals, err := t.fieldValues(val, colCfg, pgType)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, fieldVals...)
+	}
+
+	return vals, nil
+}
+
+// rangeValues returns values of the range lower, upper and bounds columns
+func (t *genericTable) rangeValues(val string, colCfg config.ColumnConfig, pgType config.PgColumn) ([]interface{}, error) {
+	vals := make([]interface{}, 0)
+
+	rng, err := utils.DecodeRange(val)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bound := range []struct {
+		column string
+		value  sql.NullString
+	}{{colCfg.LowerColumn, rng.Lower}, {colCfg.UpperColumn, rng.Upper}} {
+		if bound.column == "" {
+			continue
+		}
+
+		res, err := convertRangeBound(bound.value, t.cfg.ChColumns[bound.column], pgType, colCfg)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, res)
+	}
+
+	if colCfg.BoundsColumn != "" {
+		vals = append(vals, rng.Bounds())
+	}
+
+	return vals, nil
+}
+
+// usesColumn checks if the postgresql column is replicated into any of the clickhouse columns
+func (t *genericTable) usesColumn(pgColName string) bool {
+	_, ok := t.columnMapping[pgColName]
+
+	return ok || len(t.cfg.Columns[pgColName].CompanionColumns()) > 0 || len(t.jsonExtractions[pgColName]) > 0
+}
+
+func convert(val string, chType config.ChColumn, pgType config.PgColumn, colCfg config.ColumnConfig) (interface{}, error) {
+	if pgType.BaseType == utils.PgBytea && !pgType.IsArray {
+		return convertBytea(val, chType, colCfg)
+	}
+
+	if chType.IsArray {
+		return convertArray(val, chType, pgType, colCfg)
+	}
+
+	return convertScalar(val, chType, pgType, colCfg)
+}
+
+func convertScalar(val string, chType config.ChColumn, pgType config.PgColumn,
+	colCfg config.ColumnConfig) (interface{}, error) {
+	switch pgType.BaseType {
+	case utils.PgBytea:
+		return convertBytea(val, chType, colCfg)
+	case utils.PgInet:
+		fallthrough
+	case utils.PgCidr:
+		if chType.BaseType != utils.ChString {
+			return convertInet(val, chType)
+		}
+	case utils.PgMacAddr:
+		fallthrough
+	case utils.PgMacAddr8:
+		if chType.BaseType == utils.ChUint64 {
+			return utils.ParseMacAddr(val)
+		}
+	case utils.PgHstore:
+		if chType.BaseType == utils.ChString {
+			return convertHstore(val)
+		}
+	case utils.PgPoint:
+		fallthrough
+	case utils.PgBox:
+		fallthrough
+	case utils.PgPolygon:
+		fallthrough
+	case utils.PgGeometry:
+		fallthrough
+	case utils.PgGeography:
+		if chType.BaseType == utils.ChString {
+			return convertGeometry(val)
+		}
+	case utils.PgInterval:
+		if (chType.BaseType != utils.ChString && chType.BaseType != utils.ChFixedString) || colCfg.IntervalFormat != "" {
+			return convertInterval(val, chType, colCfg)
+		}
+	}
+
+	if _, ok := chIntTypes[chType.BaseType]; ok {
+		num, err := numericValue(val, chType, pgType, colCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return convertInt(num, chType)
+	}
+
+	switch chType.BaseType {
+	case utils.ChFloat32:
+		fallthrough
+	case utils.ChFloat64:
+		num, err := numericValue(val, chType, pgType, colCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return convertFloat(num, chType)
+	case utils.ChDecimal:
+		num, err := numericValue(val, chType, pgType, colCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return convertDecimal(num, chType, pgType)
+	case utils.ChFixedString:
+		if len(chType.Ext) > 0 && len(val) > chType.Ext[0] {
+			return nil, fmt.Errorf("value of %d bytes does not fit into FixedString(%d)", len(val), chType.Ext[0])
+		}
+
+		return val, nil
+	case utils.ChString:
+		return val, nil
+	case utils.ChDate:
+		fallthrough
+	case utils.ChDateTime:
+		fallthrough
+	case utils.ChDateTime64:
+		return convertTime(val, chType, pgType, colCfg)
+	case utils.ChUUID:
+		return val, nil
+	case utils.ChEnum8:
+		fallthrough
+	case utils.ChEnum16:
+		if _, ok := chType.EnumValues[val]; chType.EnumValues != nil && !ok {
+			return nil, fmt.Errorf("%q value is missing in the clickhouse enum, "+
+				"probably it was added to the postgresql enum type later", val)
+		}
+
+		return val, nil
+	}
+
+	return nil, fmt.Errorf("unknown type: %v", chType)
+}
+
+// convertBytea decodes bytea value into the String, FixedString(N) or Array(UInt8) column;
+// values for the String columns can be encoded into hex or base64 according to the column settings
+func convertBytea(val string, chType config.ChColumn, colCfg config.ColumnConfig) (interface{}, error) {
+	data, err := utils.DecodeBytea(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if chType.IsArray {
+		if chType.BaseType != utils.ChUInt8 || chType.ArrayDepth > 1 {
+			return nil, fmt.Errorf("can't convert bytea into array of %v", chType.BaseType)
+		}
+
+		return data, nil
+	}
+
+	switch chType.BaseType {
+	case utils.ChString:
+		switch colCfg.Encoding {
+		case config.EncodingHex:
+			return hex.EncodeToString(data), nil
+		case config.EncodingBase64:
+			return base64.StdEncoding.EncodeToString(data), nil
+		}
+
+		return string(data), nil
+	case utils.ChFixedString:
+		if len(chType.Ext) > 0 && len(data) > chType.Ext[0] {
+			return nil, fmt.Errorf("value of %d bytes does not fit into FixedString(%d)", len(data), chType.Ext[0])
+		}
+
+		return string(data), nil
+	}
+
+	return nil, fmt.Errorf("can't convert bytea into %v", chType.BaseType)
+}
+
+// convertInet converts inet or cidr value into the clickhouse IPv4, IPv6, FixedString(16) or integer columns;
+// ipv4 addresses are stored in the IPv6 columns as ipv4-mapped ones
+func convertInet(val string, chType config.ChColumn) (interface{}, error) {
+	ip, _, err := utils.ParseInet(val)
+	if err != nil {
+		return nil, err
+	}
+
+	switch chType.BaseType {
+	case utils.ChIPv4:
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("%q is not an ipv4 address", val)
+		}
+
+		return ip4, nil
+	case utils.ChFixedString:
+		if len(chType.Ext) > 0 && chType.Ext[0] < net.IPv6len {
+			return nil, fmt.Errorf("network address of %d bytes does not fit into FixedString(%d)",
+				net.IPv6len, chType.Ext[0])
+		}
+		fallthrough
+	case utils.ChIPv6:
+		return ip.To16(), nil
+	case utils.ChUint32:
+		fallthrough
+	case utils.ChInt64:
+		fallthrough
+	case utils.ChUint64:
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("%q is not an ipv4 address and can't be stored in the %s column",
+				val, chType.BaseType)
+		}
+
+		n := binary.BigEndian.Uint32(ip4)
+		switch chType.BaseType {
+		case utils.ChInt64:
+			return int64(n), nil
+		case utils.ChUint64:
+			return uint64(n), nil
+		}
+
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("can't convert %q network address into %v", val, chType.BaseType)
+}
+
+// convertHstore converts hstore value into the json object text, which JSONExtract(col, 'Map(String, Nullable(String))')
+// turns into the map
+func convertHstore(val string) (interface{}, error) {
+	pairs, err := utils.DecodeHstore(val)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]*string, len(pairs))
+	for key, pair := range pairs {
+		if pair.Valid {
+			str := pair.String
+			items[key] = &str
+		} else {
+			items[key] = nil
+		}
+	}
+
+	doc, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(doc), nil
+}
+
+// convertRangeBound converts bound of the range of the pgType type, null stands for the unbounded side
+func convertRangeBound(bound sql.NullString, chType config.ChColumn, pgType config.PgColumn,
+	colCfg config.ColumnConfig) (interface{}, error) {
+	if !bound.Valid {
+		if !chType.IsNullable {
+			return nil, fmt.Errorf("unbounded and empty ranges can be stored in the Nullable columns only")
+		}
+
+		return nil, nil
+	}
+
+	subtype, ok := utils.RangeSubtypes[pgType.BaseType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported range type: %v", pgType.BaseType)
+	}
+
+	pgBoundType := config.PgColumn{Column: config.Column{BaseType: subtype}, TimeZone: pgType.TimeZone}
+	res, err := convertScalar(bound.String, chType, pgBoundType, colCfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert %q range bound: %v", bound.String, err)
+	}
+
+	return res, nil
+}
+
+// convertGeometry converts point, box, polygon or PostGIS geometry into the well-known text, e.g. POINT(1 2),
+// which readWKTPoint, readWKTPolygon and readWKTMultiPolygon functions parse
+func convertGeometry(val string) (interface{}, error) {
+	geom, err := utils.ParseGeometry(val)
+	if err != nil {
+		return nil, err
+	}
+
+	return geom.WKT(), nil
+}
+
+// convertInterval converts interval value into the total number of seconds or milliseconds of the column's type,
+// fractional part is truncated unless the column is of float type
+func convertInterval(val string, chType config.ChColumn, colCfg config.ColumnConfig) (interface{}, error) {
+	interval, err := utils.ParseInterval(val)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := int64(microsPerSecond)
+	if colCfg.IntervalFormat == config.IntervalMilliseconds {
+		unit = microsPerSecond / 1000
+	}
+
+	if chType.BaseType == utils.ChFloat32 || chType.BaseType == utils.ChFloat64 {
+		return convertFloat(strconv.FormatFloat(float64(interval.TotalMicroseconds())/float64(unit), 'g', -1, 64), chType)
+	}
+
+	return convertInt(strconv.FormatInt(interval.TotalMicroseconds()/unit, 10), chType)
+}
+
+// convertTime converts date or timestamp into the Date, DateTime or DateTime64 column; DateTime64 keeps
+// as many digits of the fractional seconds as the column's precision allows. infinite values and the ones
+// beyond the range of the column type are clamped, stored as nulls or rejected according to the out of range policy
+func convertTime(val string, chType config.ChColumn, pgType config.PgColumn,
+	colCfg config.ColumnConfig) (interface{}, error) {
+	t, err := utils.ParseTimestamp(val, pgType.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	switch chType.BaseType {
+	case utils.ChDate:
+		// date part as it is in the source value regardless of the timezone
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case utils.ChDateTime64:
+		precision := defaultDateTime64Precision
+		if len(chType.Ext) > 0 {
+			precision = chType.Ext[0]
+		}
+		t = utils.TruncateTime(t, precision)
+	}
+
+	bounds := utils.ChTimeRanges[chType.BaseType]
+	if !t.Before(bounds[0]) && !t.After(bounds[1]) {
+		return t, nil
+	}
+
+	switch colCfg.OutOfRange {
+	case config.OutOfRangeClamp:
+		if t.Before(bounds[0]) {
+			return bounds[0], nil
+		}
+
+		return bounds[1], nil
+	case config.OutOfRangeNull:
+		if !chType.IsNullable {
+			return nil, fmt.Errorf("%q is out of the %s range and can't be stored as null in the non-nullable column",
+				val, chType.BaseType)
+		}
+
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("%q is out of the %s range", val, chType.BaseType)
+}
+
+// convertDecimal converts numeric value into the integer scaled according to the decimal's scale:
+// int32 for Decimal32, int64 for Decimal64 and 16 bytes of the little endian integer for Decimal128
+func convertDecimal(val string, chType config.ChColumn, pgType config.PgColumn) (interface{}, error) {
+	precision, scale, err := decimalParams(chType, pgType)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := utils.ParseDecimal(val, precision, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case precision <= utils.MaxDecimal32Precision:
+		return int32(res.Int64()), nil
+	case precision <= utils.MaxDecimal64Precision:
+		return res.Int64(), nil
+	}
+
+	return decimal128Bytes(res), nil
+}
+
+// decimal128Bytes returns the integer in the two's complement little endian form the driver writes Decimal128 in
+func decimal128Bytes(n *big.Int) []byte {
+	v := new(big.Int).Set(n)
+	if v.Sign() < 0 {
+		v.Add(v, new(big.Int).Lsh(big.NewInt(1), 128))
+	}
+
+	res := make([]byte, 16)
+	bigEndian := v.Bytes()
+	for i, b := range bigEndian {
+		res[len(bigEndian)-1-i] = b
+	}
+
+	return res
+}
+
+// decimalParams returns precision and scale of the clickhouse column, falls back to the ones of the postgresql column
+func decimalParams(chType config.ChColumn, pgType config.PgColumn) (int, int, error) {
+	ext := chType.Ext
+	if len(ext) != 2 {
+		ext = pgType.Ext
+	}
+
+	if len(ext) != 2 {
+		return 0, 0, fmt.Errorf("precision and scale must be specified for the decimal type")
+	}
+
+	precision, scale := ext[0], ext[1]
+	// the driver can't write Decimal256
+	if precision < 1 || precision > utils.MaxDecimal128Precision {
+		return 0, 0, fmt.Errorf("precision of decimal is out of range: %d", precision)
+	}
+
+	if scale < 0 || scale > precision {
+		return 0, 0, fmt.Errorf("scale of decimal is out of range: %d", scale)
+	}
+
+	return precision, scale, nil
+}
+
+// chGoType returns go type of the values produced by the convertScalar for the clickhouse type
+func chGoType(chType config.ChColumn, pgType config.PgColumn) (reflect.Type, error) {
+	if chType.BaseType == utils.ChDecimal {
+		precision, _, err := decimalParams(chType, pgType)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case precision <= utils.MaxDecimal32Precision:
+			return reflect.TypeOf(int32(0)), nil
+		case precision <= utils.MaxDecimal64Precision:
+			return reflect.TypeOf(int64(0)), nil
+		}
+
+		return reflect.TypeOf([]byte{}), nil
+	}
+
+	goType, ok := chGoTypes[chType.BaseType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type: %v", chType.BaseType)
+	}
+
+	return goType, nil
+}
+
+// convertArray converts postgresql array into the typed go slice, e.g. []int32 for Array(Int32)
+// or [][]*string for Array(Array(Nullable(String)))
+func convertArray(val string, chType config.ChColumn, pgType config.PgColumn,
+	colCfg config.ColumnConfig) (interface{}, error) {
+	elemType, err := chGoType(chType, pgType)
+	if err != nil {
+		return nil, err
+	}
+
+	if chType.IsNullable && elemType.Kind() != reflect.Ptr {
+		elemType = reflect.PtrTo(elemType)
+	}
+
+	items, err := utils.DecodeArray(val)
+	if err != nil {
+		return nil, err
+	}
+
+	chElemType := chType
+	chElemType.IsArray = false
+	chElemType.ArrayDepth = 0
+
+	pgElemType := pgType
+	pgElemType.IsArray = false
+
+	depth := chType.ArrayDepth
+	if depth == 0 {
+		depth = 1
+	}
+
+	res, err := arrayToSlice(items, depth, elemType, chElemType, pgElemType, colCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Interface(), nil
+}
+
+func arrayToSlice(items []interface{}, depth int, elemType reflect.Type,
+	chElemType config.ChColumn, pgElemType config.PgColumn, colCfg config.ColumnConfig) (reflect.Value, error) {
+	sliceType := elemType
+	for i := 0; i < depth; i++ {
+		sliceType = reflect.SliceOf(sliceType)
+	}
+
+	res := reflect.MakeSlice(sliceType, 0, len(items))
+	for _, item := range items {
+		var (
+			val reflect.Value
+			err error
+		)
+
+		switch item := item.(type) {
+		case []interface{}:
+			if depth == 1 {
+				return reflect.Value{}, fmt.Errorf("array has more dimensions than the clickhouse column")
+			}
+
+			val, err = arrayToSlice(item, depth-1, elemType, chElemType, pgElemType, colCfg)
+		case sql.NullString:
+			if depth > 1 {
+				return reflect.Value{}, fmt.Errorf("array has fewer dimensions than the clickhouse column")
+			}
+
+			val, err = arrayElement(item, elemType, chElemType, pgElemType, colCfg)
+		default:
+			err = fmt.Errorf("unexpected array element: %#v", item)
+		}
+
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		res = reflect.Append(res, val)
+	}
+
+	return res, nil
+}
+
+func arrayElement(item sql.NullString, elemType reflect.Type,
+	chElemType config.ChColumn, pgElemType config.PgColumn, colCfg config.ColumnConfig) (reflect.Value, error) {
+	if !item.Valid {
+		if !chElemType.IsNullable {
+			return reflect.Value{}, fmt.Errorf("got null element, which is not nullable on the ClickHouse side")
+		}
+
+		return reflect.Zero(elemType), nil
+	}
+
+	val, err := convertScalar(item.String, chElemType, pgElemType, colCfg)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("could not convert %q array element: %v", item.String, err)
+	}
+	if val == nil { // out of range value stored as null
+		return reflect.Zero(elemType), nil
+	}
+
+	return elementValue(val, elemType, chElemType.IsNullable)
+}
+
+// elementValue casts the value returned by the convertScalar func into the element type of the array or map,
+// wraps it into the pointer for the nullable elements
+func elementValue(val interface{}, elemType reflect.Type, isNullable bool) (reflect.Value, error) {
+	valType := reflect.TypeOf(val)
+	if valType == elemType {
+		return reflect.ValueOf(val), nil
+	}
+
+	targetType := elemType
+	if isNullable && valType.Kind() != reflect.Ptr {
+		targetType = elemType.Elem()
+	}
+
+	res, err := castValue(reflect.ValueOf(val), targetType)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if targetType == elemType {
+		return res, nil
+	}
+
+	ptr := reflect.New(targetType)
+	ptr.Elem().Set(res)
+
+	return ptr, nil
+}
+
+// zeroValue returns default value of the clickhouse column, the one clickhouse uses for the omitted columns
+func zeroValue(chType config.ChColumn) (interface{}, error) {
+	goType, err := chGoType(chType, config.PgColumn{})
+	if err != nil {
+		return nil, err
+	}
+
+	if chType.IsArray {
+		for i := 0; i < chType.ArrayDepth || i == 0; i++ {
+			goType = reflect.SliceOf(goType)
+		}
+
+		return reflect.MakeSlice(goType, 0, 0).Interface(), nil
+	}
+
+	switch chType.BaseType {
+	case utils.ChDate:
+		fallthrough
+	case utils.ChDateTime:
+		fallthrough
+	case utils.ChDateTime64:
+		return time.Unix(0, 0).UTC(), nil
+	case utils.ChIPv4:
+		return net.IPv4zero.To4(), nil
+	case utils.ChIPv6:
+		return net.IPv6zero, nil
+	case utils.ChEnum8:
+		fallthrough
+	case utils.ChEnum16:
+		return enumDefault(chType.EnumValues)
+	}
+
+	if goType == reflect.TypeOf([]byte{}) { // Decimal128
+		return make([]byte, 16), nil
+	}
+
+	return reflect.Zero(goType).Interface(), nil
+}
+
+// enumDefault returns the label with the lowest code, the one clickhouse uses as the enum default
+func enumDefault(values map[string]int) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("enum has no values")
+	}
+
+	labels := make([]string, 0, len(values))
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool { return values[labels[i]] < values[labels[j]] })
+
+	return labels[0], nil
+}
+
+// castValue casts the value returned by the convertScalar func into the array element type
+func castValue(val reflect.Value, targetType reflect.Type) (reflect.Value, error) {
+	if val.Type() == targetType {
+		return val, nil
+	}
+
+	if isNumericKind(val.Kind()) && isNumericKind(targetType.Kind()) {
+		return val.Convert(targetType), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("can't cast %v into %v", val.Type(), targetType)
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
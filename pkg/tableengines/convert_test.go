@@ -0,0 +1,379 @@
+package tableengines
+
+import (
+	"bytes"
+	"database/sql"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func chColumn(baseType string, ext ...int) config.ChColumn {
+	return config.ChColumn{Column: config.Column{BaseType: baseType, Ext: ext}}
+}
+
+func pgColumn(baseType string, ext ...int) config.PgColumn {
+	return config.PgColumn{Column: config.Column{BaseType: baseType, Ext: ext}}
+}
+
+func TestZeroValue(t *testing.T) {
+	enum8 := chColumn(utils.ChEnum8)
+	enum8.EnumValues = map[string]int{"paid": 2, "new": -1, "shipped": 3}
+
+	arrayInt32 := chColumn(utils.ChInt32)
+	arrayInt32.IsArray, arrayInt32.ArrayDepth = true, 1
+
+	tests := []struct {
+		chType   config.ChColumn
+		expected interface{}
+		fails    bool
+	}{
+		{chType: chColumn(utils.ChInt32), expected: int32(0)},
+		{chType: chColumn(utils.ChString), expected: ""},
+		{chType: chColumn(utils.ChDateTime), expected: time.Unix(0, 0).UTC()},
+		{chType: arrayInt32, expected: []int32{}},
+		{chType: enum8, expected: "new"},
+		{chType: chColumn(utils.ChEnum16), fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := zeroValue(tt.chType)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("zeroValue(%s): expected error, got %#v", tt.chType.BaseType, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("zeroValue(%s): unexpected error: %v", tt.chType.BaseType, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("zeroValue(%s) = %#v, expected %#v", tt.chType.BaseType, res, tt.expected)
+		}
+	}
+}
+
+func TestConvertDecimal(t *testing.T) {
+	tests := []struct {
+		val      string
+		chType   config.ChColumn
+		expected interface{}
+	}{
+		{"123.456", chColumn(utils.ChDecimal, 9, 2), int32(12346)},
+		{"-123.456", chColumn(utils.ChDecimal, 18, 3), int64(-123456)},
+		{"1", chColumn(utils.ChDecimal, 38, 0), []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"-1", chColumn(utils.ChDecimal, 38, 0),
+			[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"-2.56", chColumn(utils.ChDecimal, 20, 2),
+			[]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"18446744073709551616", chColumn(utils.ChDecimal, 20, 0), []byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		res, err := convertDecimal(tt.val, tt.chType, config.PgColumn{})
+		if err != nil {
+			t.Errorf("convertDecimal(%q, %v): unexpected error: %v", tt.val, tt.chType.Ext, err)
+			continue
+		}
+
+		if expected, ok := tt.expected.([]byte); ok {
+			if b, ok := res.([]byte); !ok || !bytes.Equal(b, expected) {
+				t.Errorf("convertDecimal(%q, %v) = %v, expected %v", tt.val, tt.chType.Ext, res, expected)
+			}
+		} else if res != tt.expected {
+			t.Errorf("convertDecimal(%q, %v) = %#v, expected %#v", tt.val, tt.chType.Ext, res, tt.expected)
+		}
+	}
+
+	if _, err := convertDecimal("1", chColumn(utils.ChDecimal, 76, 0), config.PgColumn{}); err == nil {
+		t.Errorf("expected error for the Decimal256 column")
+	}
+
+	// precision and scale of the postgresql column are used if the clickhouse column has none
+	if res, err := convertDecimal("1.5", chColumn(utils.ChDecimal), pgColumn(utils.PgNumeric, 5, 1)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if res != int32(15) {
+		t.Errorf("convertDecimal(%q) = %#v, expected %#v", "1.5", res, int32(15))
+	}
+}
+
+func TestConvertArray(t *testing.T) {
+	one, three := int32(1), int32(3)
+
+	tests := []struct {
+		val      string
+		chType   config.ChColumn
+		pgType   config.PgColumn
+		expected interface{}
+	}{
+		{
+			val:      "{1,NULL,3}",
+			chType:   config.ChColumn{Column: config.Column{BaseType: utils.ChInt32, IsArray: true, ArrayDepth: 1, IsNullable: true}},
+			pgType:   config.PgColumn{Column: config.Column{BaseType: utils.PgInteger, IsArray: true}},
+			expected: []*int32{&one, nil, &three},
+		},
+		{
+			val:      "{{1,2},{3,4}}",
+			chType:   config.ChColumn{Column: config.Column{BaseType: utils.ChInt64, IsArray: true, ArrayDepth: 2}},
+			pgType:   config.PgColumn{Column: config.Column{BaseType: utils.PgInteger, IsArray: true}},
+			expected: [][]int64{{1, 2}, {3, 4}},
+		},
+		{
+			val:      `{a,"b c"}`,
+			chType:   config.ChColumn{Column: config.Column{BaseType: utils.ChString, IsArray: true, ArrayDepth: 1}},
+			pgType:   config.PgColumn{Column: config.Column{BaseType: utils.PgText, IsArray: true}},
+			expected: []string{"a", "b c"},
+		},
+	}
+
+	for _, tt := range tests {
+		res, err := convert(tt.val, tt.chType, tt.pgType, config.ColumnConfig{})
+		if err != nil {
+			t.Errorf("convert(%q): unexpected error: %v", tt.val, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("convert(%q) = %#v, expected %#v", tt.val, res, tt.expected)
+		}
+	}
+
+	failing := []struct {
+		val    string
+		chType config.ChColumn
+	}{
+		{"{1,NULL}", config.ChColumn{Column: config.Column{BaseType: utils.ChInt32, IsArray: true, ArrayDepth: 1}}},
+		{"{{1}}", config.ChColumn{Column: config.Column{BaseType: utils.ChInt32, IsArray: true, ArrayDepth: 1}}},
+		{"{1}", config.ChColumn{Column: config.Column{BaseType: utils.ChInt32, IsArray: true, ArrayDepth: 2}}},
+		{"{300}", config.ChColumn{Column: config.Column{BaseType: utils.ChInt8, IsArray: true, ArrayDepth: 1}}},
+	}
+
+	pgType := config.PgColumn{Column: config.Column{BaseType: utils.PgInteger, IsArray: true}}
+	for _, tt := range failing {
+		if res, err := convert(tt.val, tt.chType, pgType, config.ColumnConfig{}); err == nil {
+			t.Errorf("convert(%q): expected error, got %#v", tt.val, res)
+		}
+	}
+}
+
+func TestConvertTimeClamp(t *testing.T) {
+	colCfg := config.ColumnConfig{OutOfRange: config.OutOfRangeClamp}
+
+	for _, val := range []string{"infinity", "2300-01-01 00:00:00"} {
+		res, err := convertTime(val, chColumn(utils.ChDateTime64, 6), pgColumn(utils.PgTimestamp), colCfg)
+		if err != nil {
+			t.Errorf("convertTime(%q): unexpected error: %v", val, err)
+			continue
+		}
+
+		// the driver writes DateTime64 values as nanoseconds since the unix epoch
+		if tm := res.(time.Time); tm.UnixNano() < 0 || tm.Year() != 2262 {
+			t.Errorf("convertTime(%q) = %v, expected the max value the driver can write", val, tm)
+		}
+	}
+
+	if _, err := convertTime("infinity", chColumn(utils.ChDateTime64, 6), pgColumn(utils.PgTimestamp),
+		config.ColumnConfig{}); err == nil {
+		t.Errorf("expected error for the out of range value")
+	}
+}
+
+func TestConvertInet(t *testing.T) {
+	tests := []struct {
+		val      string
+		chType   config.ChColumn
+		expected interface{}
+	}{
+		{"10.0.0.1", chColumn(utils.ChIPv4), net.IPv4(10, 0, 0, 1).To4()},
+		{"10.0.0.0/8", chColumn(utils.ChIPv6), net.IPv4(10, 0, 0, 0).To16()},
+		{"2001:db8::1", chColumn(utils.ChIPv6), net.ParseIP("2001:db8::1")},
+		{"2001:db8::1", chColumn(utils.ChFixedString, 16), net.ParseIP("2001:db8::1")},
+		{"10.0.0.1", chColumn(utils.ChUint32), uint32(0x0a000001)},
+		{"10.0.0.1", chColumn(utils.ChInt64), int64(0x0a000001)},
+		{"10.0.0.1", chColumn(utils.ChUint64), uint64(0x0a000001)},
+	}
+
+	for _, tt := range tests {
+		res, err := convertInet(tt.val, tt.chType)
+		if err != nil {
+			t.Errorf("convertInet(%q, %s): unexpected error: %v", tt.val, tt.chType.BaseType, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("convertInet(%q, %s) = %#v, expected %#v", tt.val, tt.chType.BaseType, res, tt.expected)
+		}
+	}
+
+	failing := []struct {
+		val    string
+		chType config.ChColumn
+	}{
+		{"2001:db8::1", chColumn(utils.ChIPv4)},
+		{"2001:db8::1", chColumn(utils.ChUint32)},
+		{"10.0.0.1", chColumn(utils.ChFixedString, 4)},
+		{"10.0.0.1", chColumn(utils.ChInt32)},
+		{"10.0.0", chColumn(utils.ChIPv6)},
+	}
+
+	for _, tt := range failing {
+		if res, err := convertInet(tt.val, tt.chType); err == nil {
+			t.Errorf("convertInet(%q, %s): expected error, got %#v", tt.val, tt.chType.BaseType, res)
+		}
+	}
+}
+
+func TestConvertBytea(t *testing.T) {
+	uint8Array := config.ChColumn{Column: config.Column{BaseType: utils.ChUInt8, IsArray: true, ArrayDepth: 1}}
+
+	tests := []struct {
+		chType   config.ChColumn
+		encoding string
+		expected interface{}
+	}{
+		{uint8Array, "", []byte{0xde, 0xad}},
+		{chColumn(utils.ChString), "", "\xde\xad"},
+		{chColumn(utils.ChString), config.EncodingHex, "dead"},
+		{chColumn(utils.ChString), config.EncodingBase64, "3q0="},
+		{chColumn(utils.ChFixedString, 2), "", "\xde\xad"},
+	}
+
+	for _, tt := range tests {
+		res, err := convertBytea(`\xdead`, tt.chType, config.ColumnConfig{Encoding: tt.encoding})
+		if err != nil {
+			t.Errorf("convertBytea(%s, %q): unexpected error: %v", tt.chType.BaseType, tt.encoding, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("convertBytea(%s, %q) = %#v, expected %#v", tt.chType.BaseType, tt.encoding, res, tt.expected)
+		}
+	}
+
+	for _, chType := range []config.ChColumn{
+		chColumn(utils.ChFixedString, 1),
+		chColumn(utils.ChInt32),
+		{Column: config.Column{BaseType: utils.ChInt32, IsArray: true, ArrayDepth: 1}},
+		{Column: config.Column{BaseType: utils.ChUInt8, IsArray: true, ArrayDepth: 2}},
+	} {
+		if res, err := convertBytea(`\xdead`, chType, config.ColumnConfig{}); err == nil {
+			t.Errorf("convertBytea(%s): expected error, got %#v", chType.BaseType, res)
+		}
+	}
+}
+
+func TestConvertInterval(t *testing.T) {
+	tests := []struct {
+		val      string
+		chType   config.ChColumn
+		format   string
+		expected interface{}
+	}{
+		{"01:00:01.5", chColumn(utils.ChInt64), "", int64(3601)},
+		{"01:00:01.5", chColumn(utils.ChInt32), config.IntervalSeconds, int32(3601)},
+		{"01:00:01.5", chColumn(utils.ChFloat64), "", float64(3601.5)},
+		{"01:00:01.5", chColumn(utils.ChFloat32), "", float32(3601.5)},
+		{"01:00:01.5", chColumn(utils.ChUint64), config.IntervalMilliseconds, uint64(3601500)},
+		{"-00:00:00.0015", chColumn(utils.ChFloat64), config.IntervalMilliseconds, float64(-1.5)},
+	}
+
+	for _, tt := range tests {
+		res, err := convertInterval(tt.val, tt.chType, config.ColumnConfig{IntervalFormat: tt.format})
+		if err != nil {
+			t.Errorf("convertInterval(%q, %s): unexpected error: %v", tt.val, tt.chType.BaseType, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("convertInterval(%q, %s) = %#v, expected %#v", tt.val, tt.chType.BaseType, res, tt.expected)
+		}
+	}
+
+	if res, err := convertInterval("-1 day", chColumn(utils.ChUint32), config.ColumnConfig{}); err == nil {
+		t.Errorf("expected error for the negative interval in the unsigned column, got %#v", res)
+	}
+}
+
+func TestConvertHstore(t *testing.T) {
+	hstore := pgColumn(utils.PgHstore)
+
+	res, err := convert(`"b"=>NULL, "a"=>"1", "c\"d"=>"e"`, chColumn(utils.ChString), hstore, config.ColumnConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := `{"a":"1","b":null,"c\"d":"e"}`; res != expected {
+		t.Errorf("convert() = %#v, expected %#v", res, expected)
+	}
+
+	if res, err := convert(`"a"=>`, chColumn(utils.ChString), hstore, config.ColumnConfig{}); err == nil {
+		t.Errorf("expected error for the malformed hstore, got %#v", res)
+	}
+}
+
+func TestConvertRangeBound(t *testing.T) {
+	nullableInt32 := config.ChColumn{Column: config.Column{BaseType: utils.ChInt32, IsNullable: true}}
+
+	res, err := convertRangeBound(sql.NullString{String: "42", Valid: true}, nullableInt32, pgColumn(utils.PgInt4Range),
+		config.ColumnConfig{})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if res != int32(42) {
+		t.Errorf("convertRangeBound() = %#v, expected %#v", res, int32(42))
+	}
+
+	res, err = convertRangeBound(sql.NullString{String: "2020-01-02", Valid: true}, chColumn(utils.ChDate),
+		pgColumn(utils.PgDateRange), config.ColumnConfig{})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if expected := time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC); res != expected {
+		t.Errorf("convertRangeBound() = %#v, expected %#v", res, expected)
+	}
+
+	if res, err := convertRangeBound(sql.NullString{}, nullableInt32, pgColumn(utils.PgInt4Range),
+		config.ColumnConfig{}); err != nil || res != nil {
+		t.Errorf("convertRangeBound() = %#v, %v, expected nil for the unbounded side", res, err)
+	}
+
+	if res, err := convertRangeBound(sql.NullString{}, chColumn(utils.ChInt32), pgColumn(utils.PgInt4Range),
+		config.ColumnConfig{}); err == nil {
+		t.Errorf("expected error for the unbounded side in the non-nullable column, got %#v", res)
+	}
+
+	if res, err := convertRangeBound(sql.NullString{String: "1", Valid: true}, nullableInt32, pgColumn(utils.PgInteger),
+		config.ColumnConfig{}); err == nil {
+		t.Errorf("expected error for the non-range type, got %#v", res)
+	}
+}
+
+func TestConvertGeometry(t *testing.T) {
+	tests := []struct {
+		val      string
+		pgType   string
+		expected interface{}
+	}{
+		{"(1,2)", utils.PgPoint, "POINT(1 2)"},
+		{"(1,1),(0,0)", utils.PgBox, "POLYGON((0 0,1 0,1 1,0 1,0 0))"},
+		{"((0,0),(1,0),(1,1))", utils.PgPolygon, "POLYGON((0 0,1 0,1 1,0 0))"},
+		{"0101000020E6100000000000000000F03F0000000000000040", utils.PgGeometry, "POINT(1 2)"},
+	}
+
+	for _, tt := range tests {
+		res, err := convert(tt.val, chColumn(utils.ChString), pgColumn(tt.pgType), config.ColumnConfig{})
+		if err != nil {
+			t.Errorf("convert(%q): unexpected error: %v", tt.val, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("convert(%q) = %#v, expected %#v", tt.val, res, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package tableengines
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// Converter converts text representation of the postgresql value into the value of the clickhouse column;
+// it is used instead of the built-in conversion for the columns naming it in the convert setting
+type Converter func(val string, chType config.ChColumn, pgType config.PgColumn) (interface{}, error)
+
+var (
+	convertersMutex = &sync.RWMutex{}
+	converters      = map[string]Converter{
+		"cents_to_decimal": centsToDecimal,
+		"unix_to_datetime": unixToDateTime,
+		"text":             rawText,
+	}
+)
+
+// RegisterConverter registers the converter under the name used in the convert column setting;
+// must be called before the tables are instantiated
+func RegisterConverter(name string, converter Converter) error {
+	convertersMutex.Lock()
+	defer convertersMutex.Unlock()
+
+	if _, ok := converters[name]; ok {
+		return fmt.Errorf("converter %q is already registered", name)
+	}
+	converters[name] = converter
+
+	return nil
+}
+
+// LookupConverter returns the converter registered under the name
+func LookupConverter(name string) (Converter, bool) {
+	convertersMutex.RLock()
+	defer convertersMutex.RUnlock()
+
+	converter, ok := converters[name]
+
+	return converter, ok
+}
+
+// centsToDecimal converts integer number of cents into the Decimal, Float or String column, e.g. 12345 into 123.45
+func centsToDecimal(val string, chType config.ChColumn, pgType config.PgColumn) (interface{}, error) {
+	cents, ok := new(big.Int).SetString(val, 10)
+	if !ok {
+		return nil, fmt.Errorf("%q is not an integer", val)
+	}
+
+	units, fraction := new(big.Int).QuoRem(cents, big.NewInt(100), new(big.Int))
+	sign := ""
+	if cents.Sign() < 0 {
+		sign = "-"
+		units.Abs(units)
+		fraction.Abs(fraction)
+	}
+	num := fmt.Sprintf("%s%s.%02d", sign, units.String(), fraction.Int64())
+
+	pgNumeric := config.PgColumn{Column: config.Column{BaseType: utils.PgNumeric}}
+	switch chType.BaseType {
+	case utils.ChDecimal:
+		return convertDecimal(num, chType, pgNumeric)
+	case utils.ChFloat32:
+		fallthrough
+	case utils.ChFloat64:
+		return convertFloat(num, chType)
+	case utils.ChString:
+		return num, nil
+	}
+
+	return nil, fmt.Errorf("can't convert cents into %v", chType.BaseType)
+}
+
+// unixToDateTime converts number of seconds since the unix epoch into the DateTime or DateTime64 column
+func unixToDateTime(val string, chType config.ChColumn, pgType config.PgColumn) (interface{}, error) {
+	micros, err := utils.ParseDecimal(val, utils.MaxDecimal64Precision, 6)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a number: %v", val, err)
+	}
+
+	if chType.BaseType != utils.ChDateTime && chType.BaseType != utils.ChDateTime64 {
+		return nil, fmt.Errorf("can't convert unix time into %v", chType.BaseType)
+	}
+
+	t := time.Unix(micros.Int64()/microsPerSecond, micros.Int64()%microsPerSecond*1000).UTC()
+
+	// range checks are the same as for the timestamps
+	return convertTime(t.Format("2006-01-02 15:04:05.999999"), chType,
+		config.PgColumn{Column: config.Column{BaseType: utils.PgTimestamp}, TimeZone: time.UTC}, config.ColumnConfig{})
+}
+
+// rawText stores the postgresql text representation of the value as is, e.g. bytea in the hex format
+func rawText(val string, chType config.ChColumn, pgType config.PgColumn) (interface{}, error) {
+	if chType.BaseType != utils.ChString && chType.BaseType != utils.ChFixedString {
+		return nil, fmt.Errorf("text can be stored in the String and FixedString columns only, got %s", chType.BaseType)
+	}
+
+	return val, nil
+}
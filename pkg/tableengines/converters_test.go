@@ -0,0 +1,110 @@
+package tableengines
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func TestCentsToDecimal(t *testing.T) {
+	tests := []struct {
+		val      string
+		chType   config.ChColumn
+		expected interface{}
+		fails    bool
+	}{
+		{val: "12345", chType: chColumn(utils.ChDecimal, 10, 2), expected: int64(12345)},
+		{val: "-12345", chType: chColumn(utils.ChDecimal, 10, 2), expected: int64(-12345)},
+		{val: "-5", chType: chColumn(utils.ChDecimal, 10, 2), expected: int64(-5)},
+		{val: "-5", chType: chColumn(utils.ChDecimal, 9, 3), expected: int32(-50)},
+		{val: "-5", chType: chColumn(utils.ChString), expected: "-0.05"},
+		{val: "-12345", chType: chColumn(utils.ChString), expected: "-123.45"},
+		{val: "7", chType: chColumn(utils.ChFloat64), expected: 0.07},
+		{val: "1.5", chType: chColumn(utils.ChDecimal, 10, 2), fails: true},
+		{val: "100", chType: chColumn(utils.ChInt64), fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := centsToDecimal(tt.val, tt.chType, pgColumn(utils.PgBigint))
+		if tt.fails {
+			if err == nil {
+				t.Errorf("centsToDecimal(%q): expected error, got %#v", tt.val, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("centsToDecimal(%q): unexpected error: %v", tt.val, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("centsToDecimal(%q) into %s = %#v, expected %#v", tt.val, tt.chType.BaseType, res, tt.expected)
+		}
+	}
+}
+
+func TestUnixToDateTime(t *testing.T) {
+	tests := []struct {
+		val      string
+		chType   config.ChColumn
+		expected time.Time
+		fails    bool
+	}{
+		{val: "1577934245", chType: chColumn(utils.ChDateTime), expected: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{val: "1577934245.25", chType: chColumn(utils.ChDateTime64, 3),
+			expected: time.Date(2020, 1, 2, 3, 4, 5, 250000000, time.UTC)},
+		{val: "1577934245.123456", chType: chColumn(utils.ChDateTime64, 6),
+			expected: time.Date(2020, 1, 2, 3, 4, 5, 123456000, time.UTC)},
+		{val: "-1.5", chType: chColumn(utils.ChDateTime64, 3), expected: time.Date(1969, 12, 31, 23, 59, 58, 500000000, time.UTC)},
+		{val: "now", chType: chColumn(utils.ChDateTime), fails: true},
+		{val: "1577934245", chType: chColumn(utils.ChDate), fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := unixToDateTime(tt.val, tt.chType, pgColumn(utils.PgNumeric))
+		if tt.fails {
+			if err == nil {
+				t.Errorf("unixToDateTime(%q): expected error, got %#v", tt.val, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unixToDateTime(%q): unexpected error: %v", tt.val, err)
+			continue
+		}
+
+		if ts, ok := res.(time.Time); !ok || !ts.Equal(tt.expected) {
+			t.Errorf("unixToDateTime(%q) = %#v, expected %v", tt.val, res, tt.expected)
+		}
+	}
+}
+
+func TestRegisterConverter(t *testing.T) {
+	if err := RegisterConverter("cents_to_decimal", rawText); err == nil {
+		t.Errorf("expected error for the converter registered twice")
+	}
+
+	if err := RegisterConverter("test_text", rawText); err != nil {
+		t.Fatalf("RegisterConverter(): unexpected error: %v", err)
+	}
+	defer func() {
+		convertersMutex.Lock()
+		delete(converters, "test_text")
+		convertersMutex.Unlock()
+	}()
+
+	if _, ok := LookupConverter("test_text"); !ok {
+		t.Errorf("registered converter is not found")
+	}
+	if err := RegisterConverter("test_text", rawText); err == nil {
+		t.Errorf("expected error for the converter registered twice")
+	}
+	if _, ok := LookupConverter("unknown"); ok {
+		t.Errorf("unexpected converter found for the unknown name")
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +17,7 @@ import (
 	"github.com/mkabilov/pg2ch/pkg/config"
 	"github.com/mkabilov/pg2ch/pkg/message"
 	"github.com/mkabilov/pg2ch/pkg/utils"
+	"github.com/mkabilov/pg2ch/pkg/utils/tableinfo"
 )
 
 // Generic table is a "parent" struct for all the table engines
@@ -25,16 +26,14 @@ const (
 	maxAttempts     = 100
 )
 
-const (
-	pgTrue  = "t"
-	pgFalse = "f"
-)
-
 type bufRow struct {
 	rowID int
 	data  []interface{}
 }
 
+// errSkipRow is returned if the row is not to be replicated, e.g. according to the null policy
+var errSkipRow = errors.New("row is skipped")
+
 type commandSet [][]interface{}
 
 type bufCommand []bufRow
@@ -47,54 +46,120 @@ type genericTable struct {
 
 	cfg config.Table
 
-	chUsedColumns  []string
-	pgUsedColumns  []string
-	columnMapping  map[string]config.ChColumn // [pg column name]ch column description
-	flushMutex     *sync.Mutex
-	buffer         []bufCommand
-	bufferCmdId    int // number of commands in the current buffer
-	bufferRowId    int // row id in the buffer
-	bufferFlushCnt int // number of flushed buffers
-	flushQueries   []string
-	tupleColumns   []message.Column // Columns description taken from RELATION rep message
-	generationID   *uint64
+	chUsedColumns   []string
+	pgUsedColumns   []string
+	columnMapping   map[string]config.ChColumn         // [pg column name]ch column description
+	jsonExtractions map[string][]config.JSONExtraction // [pg column name]values extracted from the json document
+	converters      map[string]Converter               // [pg column name]converter set in the column config
+	flushMutex      *sync.Mutex
+	buffer          []bufCommand
+	bufferCmdId     int // number of commands in the current buffer
+	bufferRowId     int // row id in the buffer
+	bufferFlushCnt  int // number of flushed buffers
+	flushQueries    []string
+	tupleColumns    []message.Column // Columns description taken from RELATION rep message
+	generationID    *uint64
+	toastCache      *toastCache   // rows last seen by the replicator, nil unless used by the toast fallback
+	rowStore        *rowStore     // last known images of the rows, nil unless the table uses the row image store
+	shards          []*shard      // shards the rows are written into directly, nil unless the table uses shard writes
+	shardColumns    []int         // indexes of the sharding columns among the values of the row
+	shardSlots      []uint64      // cumulative weights of the shards, the row goes to the first one above its slot
+	txBegin         message.Begin // begin message of the transaction being replicated
 }
 
 func newGenericTable(ctx context.Context, chConn *sql.DB, tblCfg config.Table, genID *uint64) genericTable {
 	t := genericTable{
-		ctx:           ctx,
-		chConn:        chConn,
-		cfg:           tblCfg,
-		columnMapping: make(map[string]config.ChColumn),
-		chUsedColumns: make([]string, 0),
-		pgUsedColumns: make([]string, 0),
-		flushMutex:    &sync.Mutex{},
-		tupleColumns:  tblCfg.TupleColumns,
-		generationID:  genID,
+		ctx:             ctx,
+		chConn:          chConn,
+		cfg:             tblCfg,
+		columnMapping:   make(map[string]config.ChColumn),
+		jsonExtractions: make(map[string][]config.JSONExtraction),
+		converters:      make(map[string]Converter),
+		chUsedColumns:   make([]string, 0),
+		pgUsedColumns:   make([]string, 0),
+		flushMutex:      &sync.Mutex{},
+		tupleColumns:    tblCfg.TupleColumns,
+		generationID:    genID,
 	}
 
 	t.buffer = make([]bufCommand, t.cfg.MaxBufferLength)
 
+	for _, fallback := range tblCfg.ToastFallback {
+		if fallback == config.ToastFallbackCache {
+			t.toastCache = newToastCache(tblCfg.ToastCacheSize)
+		}
+	}
+
+	if tblCfg.RowImageStore {
+		t.rowStore = newRowStore(tblCfg.RowImageStorePath)
+	}
+
+	for _, extraction := range tblCfg.JSONExtract {
+		t.jsonExtractions[extraction.PgColumn] = append(t.jsonExtractions[extraction.PgColumn], extraction)
+	}
+
 	for _, pgCol := range t.tupleColumns {
 		chCol, ok := tblCfg.ColumnMapping[pgCol.Name]
-		if !ok {
+		companionColumns := tblCfg.Columns[pgCol.Name].CompanionColumns()
+		if !ok && len(companionColumns) == 0 && len(t.jsonExtractions[pgCol.Name]) == 0 {
 			continue
 		}
 
-		t.columnMapping[pgCol.Name] = chCol
-		t.chUsedColumns = append(t.chUsedColumns, chCol.Name)
+		if ok {
+			t.columnMapping[pgCol.Name] = chCol
+			t.chUsedColumns = append(t.chUsedColumns, chCol.Name)
+
+			// converter names are checked on the config fetching
+			if converter, ok := LookupConverter(tblCfg.Columns[pgCol.Name].Convert); ok {
+				t.converters[pgCol.Name] = converter
+			}
+		}
+		t.chUsedColumns = append(t.chUsedColumns, companionColumns...)
 		t.pgUsedColumns = append(t.pgUsedColumns, pgCol.Name)
+
+		for _, extraction := range t.jsonExtractions[pgCol.Name] {
+			t.chUsedColumns = append(t.chUsedColumns, extraction.Column)
+		}
 	}
 
 	if tblCfg.GenerationColumn != "" {
 		t.chUsedColumns = append(t.chUsedColumns, tblCfg.GenerationColumn)
 	}
 
+	// sharding columns are checked to be replicated on the config fetching
+	for _, pgColName := range tblCfg.ShardBy {
+		for i, chColName := range t.chUsedColumns {
+			if chColName == t.columnMapping[pgColName].Name {
+				t.shardColumns = append(t.shardColumns, i)
+				break
+			}
+		}
+	}
+
 	return t
 }
 
+// mainTable returns the table the rows are written into and looked up in: the distributed table in front of
+// the main table, if any, or the main table itself, which is the local table of the shard for the shard writes
+func (t *genericTable) mainTable() string {
+	if t.cfg.DistributedTable != "" && !t.cfg.ShardWrites {
+		return t.cfg.DistributedTable
+	}
+
+	return t.cfg.ChMainTable
+}
+
+// onCluster returns ON CLUSTER clause of the queries run on every node of the cluster, if the cluster is set
+func (t *genericTable) onCluster() string {
+	if t.cfg.Cluster == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" ON CLUSTER %s", t.cfg.Cluster)
+}
+
 func (t *genericTable) truncateMainTable() error {
-	if _, err := t.chConn.Exec(fmt.Sprintf("truncate table %s", t.cfg.ChMainTable)); err != nil {
+	if _, err := t.chConn.Exec(fmt.Sprintf("truncate table %s%s", t.cfg.ChMainTable, t.onCluster())); err != nil {
 		return err
 	}
 
@@ -106,6 +171,10 @@ func (t *genericTable) truncateBufTable() error {
 		return nil
 	}
 
+	if t.shards != nil {
+		return t.truncateShardBufTables()
+	}
+
 	if _, err := t.chConn.Exec(fmt.Sprintf("truncate table %s", t.cfg.ChBufferTable)); err != nil {
 		return err
 	}
@@ -124,7 +193,7 @@ func (t *genericTable) stmntPrepare(sync bool) error {
 		tableName = t.cfg.ChBufferTable
 		columns = append(columns, t.cfg.BufferTableRowIdColumn)
 	} else {
-		tableName = t.cfg.ChMainTable
+		tableName = t.mainTable()
 	}
 
 	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
@@ -132,7 +201,11 @@ func (t *genericTable) stmntPrepare(sync bool) error {
 		strings.Join(columns, ", "),
 		strings.Join(strings.Split(strings.Repeat("?", len(columns)), ""), ", "))
 
-	t.chStmnt, err = t.chTx.Prepare(query)
+	if t.shards != nil {
+		err = t.prepareShards(query)
+	} else {
+		t.chStmnt, err = t.chTx.Prepare(query)
+	}
 	if err != nil {
 		return fmt.Errorf("could not prepare statement: %v", err)
 	}
@@ -141,12 +214,20 @@ func (t *genericTable) stmntPrepare(sync bool) error {
 }
 
 func (t *genericTable) stmntExec(params []interface{}) error {
+	if t.shards != nil {
+		return t.execShard(params)
+	}
+
 	_, err := t.chStmnt.Exec(params...)
 
 	return err
 }
 
 func (t *genericTable) begin() (err error) {
+	if t.shards != nil {
+		return t.beginShards()
+	}
+
 	t.chTx, err = t.chConn.Begin()
 
 	return
@@ -178,6 +259,12 @@ func (t *genericTable) genSync(pgTx *pgx.Tx, w io.Writer) error {
 		log.Printf("Could not get approx number of rows in the source table: %v", err)
 	}
 
+	if t.rowStore != nil {
+		if err := t.rowStore.reset(); err != nil {
+			return fmt.Errorf("could not reset row image store: %v", err)
+		}
+	}
+
 	if t.cfg.ChBufferTable != "" && !t.cfg.InitSyncSkipBufferTable {
 		log.Printf("Copy from %s postgres table to %q clickhouse table via %q buffer table started. ~%v rows to copy",
 			t.cfg.PgTableName.String(), t.cfg.ChMainTable, t.cfg.ChBufferTable, tblLiveTuples)
@@ -227,6 +314,10 @@ func (t *genericTable) genSync(pgTx *pgx.Tx, w io.Writer) error {
 }
 
 func (t *genericTable) stmntCloseCommit() error {
+	if t.shards != nil {
+		return t.closeCommitShards()
+	}
+
 	if err := t.chStmnt.Close(); err != nil {
 		return fmt.Errorf("could not close statement: %v", err)
 	}
@@ -250,8 +341,15 @@ func (t *genericTable) bufferAppend(cmdSet commandSet) {
 }
 
 func (t *genericTable) processCommandSet(set commandSet) (bool, error) {
-	if set != nil {
-		t.bufferAppend(set)
+	commands := make(commandSet, 0, len(set))
+	for _, cmd := range set {
+		if cmd != nil { // skipped row
+			commands = append(commands, cmd)
+		}
+	}
+
+	if len(commands) > 0 {
+		t.bufferAppend(commands)
 	}
 
 	if t.bufferCmdId == t.cfg.MaxBufferLength {
@@ -281,6 +379,10 @@ func (t *genericTable) syncConvertIntoRow(p []byte) ([]interface{}, int, error)
 		return nil, 0, fmt.Errorf("could not parse record: %v", err)
 	}
 
+	if err := t.rememberSyncRow(rec); err != nil {
+		return nil, 0, err
+	}
+
 	return row, len(p), nil
 }
 
@@ -291,7 +393,7 @@ func (t *genericTable) insertRow(row []interface{}) error {
 		chTableName = t.cfg.ChBufferTable
 		row = append(row, t.bufferRowId)
 	} else {
-		chTableName = t.cfg.ChMainTable
+		chTableName = t.mainTable()
 	}
 
 	if err := t.stmntExec(row); err != nil {
@@ -321,11 +423,16 @@ func (t *genericTable) flushBuffer() error {
 		log.Printf("could not flush buffer: %v, retrying after %v", err, attemptInterval)
 		select {
 		case <-t.ctx.Done():
+			t.resetShards()
 			return fmt.Errorf("abort retrying")
 		case <-time.After(attemptInterval):
 		}
 	}
 
+	if err != nil {
+		t.resetShards()
+	}
+
 	return err
 }
 
@@ -367,10 +474,16 @@ func (t *genericTable) attemptFlushBuffer() error {
 }
 
 func (t *genericTable) tryFlushToMainTable() error { //TODO: consider better name
-	for _, query := range t.flushQueries {
-		if _, err := t.chConn.Exec(query); err != nil {
+	if t.shards != nil {
+		if err := t.flushShardsToMainTable(); err != nil {
 			return err
 		}
+	} else {
+		for _, query := range t.flushQueries {
+			if _, err := t.chConn.Exec(query); err != nil {
+				return err
+			}
+		}
 	}
 
 	t.bufferFlushCnt = 0
@@ -389,7 +502,7 @@ func (t *genericTable) FlushToMainTable() error {
 	}
 
 	if t.cfg.ChBufferTable == "" || t.bufferFlushCnt == 0 {
-		return nil
+		return t.commitRowImages()
 	}
 
 	defer func(startTime time.Time, rows int) {
@@ -409,6 +522,7 @@ func (t *genericTable) FlushToMainTable() error {
 		log.Printf("could not flush: %v, retrying after %v", err, attemptInterval)
 		select {
 		case <-t.ctx.Done():
+			t.resetShards()
 			return fmt.Errorf("abort retrying")
 		case <-time.After(attemptInterval):
 		}
@@ -418,89 +532,57 @@ func (t *genericTable) FlushToMainTable() error {
 		return fmt.Errorf("could not truncate buffer table: %v", err)
 	}
 
-	return nil
+	return t.commitRowImages()
 }
 
-func convert(val string, chType config.ChColumn, pgType config.PgColumn) (interface{}, error) {
-	switch chType.BaseType {
-	case utils.ChInt8:
-		return strconv.ParseInt(val, 10, 8)
-	case utils.ChInt16:
-		return strconv.ParseInt(val, 10, 16)
-	case utils.ChInt32:
-		return strconv.ParseInt(val, 10, 32)
-	case utils.ChInt64:
-		return strconv.ParseInt(val, 10, 64)
-	case utils.ChUInt8:
-		if pgType.BaseType == utils.PgBoolean {
-			if val == pgTrue {
-				return 1, nil
-			} else if val == pgFalse {
-				return 0, nil
-			}
-		}
-
-		return nil, fmt.Errorf("can't convert %v to %v", pgType.BaseType, chType.BaseType)
-	case utils.ChUInt16:
-		return strconv.ParseUint(val, 10, 16)
-	case utils.ChUint32:
-		if pgType.BaseType == utils.PgTimeWithoutTimeZone {
-			t, err := time.Parse("15:04:05", val)
-			if err != nil {
-				return nil, err
-			}
+// convertTuples converts the row into the values of the clickhouse columns, returns nil if the row is to be skipped
+func (t *genericTable) convertTuples(row message.Row) ([]interface{}, error) {
+	return t.convertRow(row, t.unchangedColumn)
+}
 
-			return t.Hour()*3600 + t.Minute()*60 + t.Second(), nil
-		}
+// convertKeyTuples converts the row which is only needed to be identified by its key, e.g. the deleted one;
+// values postgresql didn't send are replaced with the default ones
+func (t *genericTable) convertKeyTuples(row message.Row) ([]interface{}, error) {
+	return t.convertRow(row, func(row message.Row, colId int) ([]interface{}, error) {
+		return t.defaultValues(t.tupleColumns[colId].Name)
+	})
+}
 
-		return strconv.ParseUint(val, 10, 32)
-	case utils.ChUint64:
-		return strconv.ParseUint(val, 10, 64)
-	case utils.ChFloat32:
-		return strconv.ParseFloat(val, 32)
-	case utils.ChDecimal:
-		return strconv.ParseFloat(val, 64)
-	case utils.ChFloat64:
-		return strconv.ParseFloat(val, 64)
-	case utils.ChFixedString:
-		fallthrough
-	case utils.ChString:
-		return val, nil
-	case utils.ChDate:
-		return time.Parse("2006-01-02", val[:10])
-	case utils.ChDateTime:
-		return time.Parse("2006-01-02 15:04:05", val[:19])
-	case utils.ChUUID:
-		return val, nil
-	}
-
-	return nil, fmt.Errorf("unknown type: %v", chType)
-}
-
-func (t *genericTable) convertTuples(row message.Row) []interface{} {
+// convertRow converts the row, values postgresql didn't send are converted by the unchanged func
+func (t *genericTable) convertRow(row message.Row,
+	unchanged func(row message.Row, colId int) ([]interface{}, error)) ([]interface{}, error) {
 	var err error
 	res := make([]interface{}, 0)
 
 	for colId, col := range t.tupleColumns {
-		var val interface{}
-		if _, ok := t.columnMapping[col.Name]; !ok {
+		var vals []interface{}
+		if !t.usesColumn(col.Name) {
 			continue
 		}
 
-		if row[colId].Kind != message.TupleNull {
-			val, err = convert(string(row[colId].Value), t.columnMapping[col.Name], t.cfg.PgColumns[col.Name])
-			if err != nil {
-				panic(err)
-			}
+		switch row[colId].Kind {
+		case message.TupleNull:
+			vals, err = t.nullColumn(col.Name)
+		case message.TupleUnchanged:
+			vals, err = unchanged(row, colId)
+		default:
+			vals, err = t.convertColumn(col.Name, string(row[colId].Value))
+		}
+		if err == errSkipRow {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not convert row of the %s table: %v", t.cfg.PgTableName.String(), err)
 		}
 
-		res = append(res, val)
+		res = append(res, vals...)
 	}
+	t.cacheRow(row)
+
 	if t.cfg.GenerationColumn != "" {
 		res = append(res, uint32(*t.generationID))
 	}
 
-	return res
+	return res, nil
 }
 
 // gets row from the copy
@@ -508,22 +590,27 @@ func (t *genericTable) syncConvertStrings(fields []sql.NullString) ([]interface{
 	res := make([]interface{}, 0)
 	for i, field := range fields {
 		pgColName := t.pgUsedColumns[i]
-		column := t.columnMapping[pgColName]
 
 		if !field.Valid {
-			if !column.IsNullable {
-				return nil, fmt.Errorf("got null in %s field, which is not nullable on the ClickHouse side", pgColName)
+			vals, err := t.nullColumn(pgColName)
+			if err == errSkipRow {
+				return nil, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("could not convert row of the %s table: %v", t.cfg.PgTableName.String(), err)
 			}
-			res = append(res, nil)
+
+			res = append(res, vals...)
 			continue
 		}
 
-		val, err := convert(field.String, column, t.cfg.PgColumns[pgColName])
-		if err != nil {
-			return nil, fmt.Errorf("could not parse %q field with %s type: %v", pgColName, column.BaseType, err)
+		vals, err := t.convertColumn(pgColName, field.String)
+		if err == errSkipRow {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not convert row of the %s table: %v", t.cfg.PgTableName.String(), err)
 		}
 
-		res = append(res, val)
+		res = append(res, vals...)
 	}
 
 	return res, nil
@@ -532,6 +619,7 @@ func (t *genericTable) syncConvertStrings(fields []sql.NullString) ([]interface{
 // Truncate truncates main and buffer(if used) tables
 func (t *genericTable) Truncate() error {
 	t.bufferCmdId = 0
+	t.resetRowCaches()
 
 	if err := t.truncateMainTable(); err != nil {
 		return err
@@ -540,35 +628,85 @@ func (t *genericTable) Truncate() error {
 	return t.truncateBufTable()
 }
 
+// resetRowCaches forgets the rows seen by the replicator, e.g. after the table is truncated
+func (t *genericTable) resetRowCaches() {
+	if t.toastCache != nil {
+		t.toastCache.reset()
+	}
+	if t.rowStore != nil {
+		t.rowStore.eraseAll()
+	}
+}
+
+// SetTransaction sets the transaction the following changes belong to
+func (t *genericTable) SetTransaction(begin message.Begin) {
+	t.txBegin = begin
+}
+
 // Init performs initialization
 func (t *genericTable) Init() error {
 	return t.truncateBufTable()
 }
 
+// tupleCommand converts the row and appends values of the engine's service columns, e.g. sign;
+// returns nil if the row is to be skipped
+func (t *genericTable) tupleCommand(row message.Row, serviceValues ...interface{}) ([]interface{}, error) {
+	res, err := t.convertTuples(row)
+	if res == nil || err != nil {
+		return nil, err
+	}
+
+	return append(res, serviceValues...), nil
+}
+
+// keyTupleCommand is the same as tupleCommand for the rows which are only needed to be identified by the key
+func (t *genericTable) keyTupleCommand(row message.Row, serviceValues ...interface{}) ([]interface{}, error) {
+	res, err := t.convertKeyTuples(row)
+	if res == nil || err != nil {
+		return nil, err
+	}
+
+	return append(res, serviceValues...), nil
+}
+
 // SetTupleColumns sets the tuple columns
 func (t *genericTable) SetTupleColumns(tupleColumns []message.Column) {
 	//TODO: suggest alter table message for adding/deleting new/old columns on clickhouse side
 	t.tupleColumns = tupleColumns
+	if t.toastCache != nil { // cached rows might have the old set of columns
+		t.toastCache.reset()
+	}
 }
 
+// SetColumnType sets the type of the postgresql column, e.g. after the composite type of the column was altered
+func (t *genericTable) SetColumnType(colName string, pgType config.PgColumn) {
+	pgCol, ok := t.cfg.PgColumns[colName]
+	if !ok {
+		return
+	}
+
+	t.cfg.PgColumns[colName] = tableinfo.SetColumnType(pgCol, pgType)
+}
+
+// compareRows checks if the rows are equal and if the key of the row, see keyColumns, changed
 func (t *genericTable) compareRows(a, b message.Row) (bool, bool) {
+	keyColumns := make(map[int]struct{})
+	for _, colId := range t.keyColumns() {
+		keyColumns[colId] = struct{}{}
+	}
+
 	equal := true
 	keyColumnChanged := false
 	for colId, col := range t.tupleColumns {
-		if _, ok := t.columnMapping[col.Name]; !ok {
+		if !t.usesColumn(col.Name) || b[colId].Kind == message.TupleUnchanged {
 			continue
 		}
 
-		if a[colId].Kind != message.TupleNull {
-			if !bytes.Equal(a[colId].Value, b[colId].Value) {
-				equal = false
-				if col.IsKey {
-					keyColumnChanged = true
-				}
-			}
-		} else if b[colId].Kind == message.TupleNull {
+		if a[colId].Kind == message.TupleUnchanged { // unknown old value
+			equal = false
+		} else if a[colId].Kind != b[colId].Kind || !bytes.Equal(a[colId].Value, b[colId].Value) {
 			equal = false
-			if col.IsKey { // should never happen
+			if _, ok := keyColumns[colId]; ok {
 				keyColumnChanged = true
 			}
 		}
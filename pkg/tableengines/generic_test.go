@@ -0,0 +1,149 @@
+package tableengines
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// testTableConfig returns config of the table with the id primary key and the region and amount columns
+func testTableConfig() config.Table {
+	id := pgColumn(utils.PgInteger)
+	id.PkCol = 1
+
+	return config.Table{
+		PgTableName:            config.PgTableName{SchemaName: "public", TableName: "sales"},
+		ChMainTable:            "sales",
+		ChBufferTable:          "sales_buf",
+		BufferTableRowIdColumn: "row_id",
+		MaxBufferLength:        10,
+		TupleColumns: []message.Column{
+			{IsKey: true, Name: "id"},
+			{Name: "region"},
+			{Name: "amount"},
+		},
+		PgColumns: map[string]config.PgColumn{
+			"id":     id,
+			"region": pgColumn(utils.PgText),
+			"amount": pgColumn(utils.PgNumeric),
+		},
+		ColumnMapping: map[string]config.ChColumn{
+			"id":     {Name: "id", Column: config.Column{BaseType: utils.ChInt32}},
+			"region": {Name: "region", Column: config.Column{BaseType: utils.ChString, IsNullable: true}},
+			"amount": {Name: "amount", Column: config.Column{BaseType: utils.ChInt64, IsNullable: true}},
+		},
+	}
+}
+
+func text(val string) message.Tuple {
+	return message.Tuple{Kind: message.TupleText, Value: []byte(val)}
+}
+
+var (
+	nullTuple      = message.Tuple{Kind: message.TupleNull, Value: []byte{}}
+	unchangedTuple = message.Tuple{Kind: message.TupleUnchanged, Value: []byte{}}
+)
+
+func TestCompareRows(t *testing.T) {
+	tbl := newGenericTable(context.Background(), nil, testTableConfig(), new(uint64))
+
+	tests := []struct {
+		old        message.Row
+		new        message.Row
+		equal      bool
+		keyChanged bool
+	}{
+		{old: message.Row{text("1"), text("eu"), text("10")}, new: message.Row{text("1"), text("eu"), text("10")},
+			equal: true},
+		{old: message.Row{text("1"), text("eu"), text("10")}, new: message.Row{text("1"), text("eu"), text("20")}},
+		{old: message.Row{text("1"), nullTuple, text("10")}, new: message.Row{text("1"), text("eu"), text("10")}},
+		{old: message.Row{text("1"), text("eu"), text("10")}, new: message.Row{text("1"), nullTuple, text("10")}},
+		{old: message.Row{text("1"), nullTuple, text("10")}, new: message.Row{text("1"), nullTuple, text("10")},
+			equal: true},
+		{old: message.Row{text("1"), text(""), text("10")}, new: message.Row{text("1"), nullTuple, text("10")}},
+		{old: message.Row{text("1"), unchangedTuple, text("10")}, new: message.Row{text("1"), text("eu"), text("10")}},
+		{old: message.Row{text("1"), text("eu"), text("10")}, new: message.Row{text("1"), unchangedTuple, text("10")},
+			equal: true},
+		{old: message.Row{text("1"), text("eu"), text("10")}, new: message.Row{text("2"), text("eu"), text("10")},
+			keyChanged: true},
+	}
+
+	for i, tt := range tests {
+		equal, keyChanged := tbl.compareRows(tt.old, tt.new)
+		if equal != tt.equal || keyChanged != tt.keyChanged {
+			t.Errorf("#%d compareRows() = %v, %v, expected %v, %v", i, equal, keyChanged, tt.equal, tt.keyChanged)
+		}
+	}
+
+	// with the full replica identity every column is the identity one, the key is the primary key
+	cfg := testTableConfig()
+	for i := range cfg.TupleColumns {
+		cfg.TupleColumns[i].IsKey = true
+	}
+	tbl = newGenericTable(context.Background(), nil, cfg, new(uint64))
+
+	old := message.Row{text("1"), text("eu"), text("10")}
+	if equal, keyChanged := tbl.compareRows(old, message.Row{text("1"), text("us"), text("10")}); equal || keyChanged {
+		t.Errorf("compareRows() = %v, %v for the changed non-key column, expected false, false", equal, keyChanged)
+	}
+	if equal, keyChanged := tbl.compareRows(old, message.Row{text("2"), text("eu"), text("10")}); equal || !keyChanged {
+		t.Errorf("compareRows() = %v, %v for the changed primary key, expected false, true", equal, keyChanged)
+	}
+}
+
+func TestNullPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		literal  string
+		expected []interface{}
+		fails    bool
+	}{
+		{policy: config.NullPolicyDefault, expected: []interface{}{int32(1), "eu", int64(0)}},
+		{policy: config.NullPolicyLiteral, literal: "-1", expected: []interface{}{int32(1), "eu", int64(-1)}},
+		{policy: config.NullPolicySkip, expected: nil},
+		{policy: config.NullPolicyError, fails: true},
+		{policy: "", fails: true},
+	}
+
+	for _, tt := range tests {
+		cfg := testTableConfig()
+		cfg.NullPolicy = tt.policy
+		cfg.Columns = map[string]config.ColumnConfig{"amount": {NullLiteral: tt.literal}}
+		amount := cfg.ColumnMapping["amount"]
+		amount.IsNullable = false
+		cfg.ColumnMapping["amount"] = amount
+
+		tbl := newGenericTable(context.Background(), nil, cfg, new(uint64))
+
+		replicated, err := tbl.convertTuples(message.Row{text("1"), text("eu"), nullTuple})
+		synced, syncErr := tbl.syncConvertStrings([]sql.NullString{
+			{String: "1", Valid: true}, {String: "eu", Valid: true}, {}})
+
+		for _, res := range []struct {
+			mode string
+			vals []interface{}
+			err  error
+		}{{"replication", replicated, err}, {"sync", synced, syncErr}} {
+			if tt.fails {
+				if res.err == nil {
+					t.Errorf("%s with %q null policy: expected error, got %#v", res.mode, tt.policy, res.vals)
+				}
+				continue
+			}
+
+			if res.err != nil {
+				t.Errorf("%s with %q null policy: unexpected error: %v", res.mode, tt.policy, res.err)
+				continue
+			}
+
+			if !reflect.DeepEqual(res.vals, tt.expected) {
+				t.Errorf("%s with %q null policy = %#v, expected %#v", res.mode, tt.policy, res.vals, tt.expected)
+			}
+		}
+	}
+}
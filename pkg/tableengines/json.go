@@ -0,0 +1,88 @@
+package tableengines
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// extractJSON extracts values of the json document into the clickhouse columns; missing keys and nulls
+// are stored as nulls or, for the non-nullable columns, as the default values, the same way JSONExtract functions do
+func extractJSON(doc string, extractions []config.JSONExtraction) ([]interface{}, error) {
+	parsed, err := utils.DecodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([]interface{}, len(extractions))
+	for i, extraction := range extractions {
+		val, err := convertJSONValue(utils.JSONPath(parsed, extraction.Keys), extraction.ChColumn)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert %q value: %v", extraction.Path, err)
+		}
+		vals[i] = val
+	}
+
+	return vals, nil
+}
+
+// nullJSONExtractions returns values of the extraction columns for the null json document
+func nullJSONExtractions(extractions []config.JSONExtraction) ([]interface{}, error) {
+	vals := make([]interface{}, len(extractions))
+	for i, extraction := range extractions {
+		val, err := convertJSONValue(nil, extraction.ChColumn)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert %q value: %v", extraction.Path, err)
+		}
+		vals[i] = val
+	}
+
+	return vals, nil
+}
+
+// convertJSONValue converts decoded json value into the clickhouse column;
+// nested objects and arrays can be stored in the String columns only, as json text
+func convertJSONValue(val interface{}, chType config.ChColumn) (interface{}, error) {
+	if val == nil {
+		if chType.IsNullable {
+			return nil, nil
+		}
+
+		return zeroValue(chType)
+	}
+
+	if chType.IsArray {
+		return nil, fmt.Errorf("can't extract json value into the array column")
+	}
+
+	var (
+		str    string
+		pgType = config.PgColumn{Column: config.Column{BaseType: utils.PgText}}
+	)
+
+	switch val := val.(type) {
+	case string:
+		str = val
+	case json.Number:
+		str = val.String()
+	case bool:
+		str = strconv.FormatBool(val)
+		if chType.BaseType == utils.ChUInt8 {
+			pgType.BaseType, str = utils.PgBoolean, pgFalse
+			if val {
+				str = pgTrue
+			}
+		}
+	default:
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		pgType.BaseType, str = utils.PgJsonb, string(raw)
+	}
+
+	return convertScalar(str, chType, pgType, config.ColumnConfig{})
+}
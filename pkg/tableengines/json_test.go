@@ -0,0 +1,47 @@
+package tableengines
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func TestExtractJSON(t *testing.T) {
+	nullable := func(baseType string) config.ChColumn {
+		return config.ChColumn{Column: config.Column{BaseType: baseType, IsNullable: true}}
+	}
+
+	extractions := []config.JSONExtraction{
+		{Keys: []string{"user", "id"}, ChColumn: chColumn(utils.ChUint64)},
+		{Keys: []string{"user", "name"}, ChColumn: chColumn(utils.ChString)},
+		{Keys: []string{"tags", "1"}, ChColumn: chColumn(utils.ChString)},
+		{Keys: []string{"tags"}, ChColumn: chColumn(utils.ChString)},
+		{Keys: []string{"active"}, ChColumn: chColumn(utils.ChUInt8)},
+		{Keys: []string{"price"}, ChColumn: chColumn(utils.ChDecimal, 10, 2)},
+		{Keys: []string{"missing"}, ChColumn: nullable(utils.ChInt32)},
+		{Keys: []string{"missing"}, ChColumn: chColumn(utils.ChInt32)},
+		{Keys: []string{"user", "id", "x"}, ChColumn: chColumn(utils.ChString)},
+	}
+
+	doc := `{"user": {"id": 18446744073709551615, "name": "bob"}, "tags": ["a", "b"], "active": true, "price": 1.25}`
+	expected := []interface{}{uint64(18446744073709551615), "bob", "b", `["a","b"]`, uint8(1), int64(125), nil, int32(0), ""}
+
+	res, err := extractJSON(doc, extractions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("extractJSON() = %#v, expected %#v", res, expected)
+	}
+
+	if res, err := extractJSON(`{"user": {"id": -1}}`, extractions[:1]); err == nil {
+		t.Errorf("expected error for the negative value in the unsigned column, got %#v", res)
+	}
+
+	if res, err := extractJSON(`{"user":`, extractions); err == nil {
+		t.Errorf("expected error for the malformed document, got %#v", res)
+	}
+}
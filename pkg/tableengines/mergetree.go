@@ -28,7 +28,7 @@ func NewMergeTree(ctx context.Context, conn *sql.DB, tblCfg config.Table, genID
 	}
 
 	t.flushQueries = []string{fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[3]s ORDER BY %[4]s",
-		t.cfg.ChMainTable, strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable, t.cfg.BufferTableRowIdColumn)}
+		t.mainTable(), strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable, t.cfg.BufferTableRowIdColumn)}
 
 	return &t
 }
@@ -47,6 +47,10 @@ func (t *mergeTreeTable) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
+	if row == nil { // skipped according to the null policy
+		return n, nil
+	}
+
 	if t.cfg.GenerationColumn != "" {
 		row = append(row, 0)
 	}
@@ -56,7 +60,12 @@ func (t *mergeTreeTable) Write(p []byte) (int, error) {
 
 // Insert handles incoming insert DML operation
 func (t *mergeTreeTable) Insert(lsn utils.LSN, new message.Row) (bool, error) {
-	return t.processCommandSet(commandSet{t.convertTuples(new)})
+	cmd, err := t.tupleCommand(new)
+	if err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
 }
 
 // Update handles incoming update DML operation
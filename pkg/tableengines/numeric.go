@@ -0,0 +1,251 @@
+package tableengines
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+const microsPerSecond = 1000000
+
+// sizes of the clickhouse integer types
+var chIntTypes = map[string]struct {
+	bits   uint
+	signed bool
+}{
+	utils.ChInt8:   {8, true},
+	utils.ChInt16:  {16, true},
+	utils.ChInt32:  {32, true},
+	utils.ChInt64:  {64, true},
+	utils.ChUInt8:  {8, false},
+	utils.ChUInt16: {16, false},
+	utils.ChUint32: {32, false},
+	utils.ChUint64: {64, false},
+}
+
+// numericValue prepares the value of the boolean, time or timetz column for the numeric clickhouse column:
+// booleans become 1 or 0, time of day becomes the number of seconds or microseconds since midnight
+func numericValue(val string, chType config.ChColumn, pgType config.PgColumn, colCfg config.ColumnConfig) (string, error) {
+	switch pgType.BaseType {
+	case utils.PgBoolean:
+		b, err := parseBool(val)
+		if err != nil {
+			return "", err
+		}
+
+		if b {
+			return "1", nil
+		}
+
+		return "0", nil
+	case utils.PgTime:
+		fallthrough
+	case utils.PgTimeWithoutTimeZone:
+		fallthrough
+	case utils.PgTimeWithTimeZone:
+		micros, err := parseTimeOfDay(val)
+		if err != nil {
+			return "", err
+		}
+
+		if colCfg.TimeUnit == config.TimeUnitMicroseconds {
+			return strconv.FormatInt(micros, 10), nil
+		}
+
+		// fractional seconds are kept for the float and decimal columns only
+		if _, ok := chIntTypes[chType.BaseType]; ok {
+			return strconv.FormatInt(micros/microsPerSecond, 10), nil
+		}
+
+		return fmt.Sprintf("%d.%06d", micros/microsPerSecond, micros%microsPerSecond), nil
+	}
+
+	return val, nil
+}
+
+// convertInt converts integer, numeric or float value into the clickhouse integer column;
+// values out of the column's range and the ones with the fractional part are rejected
+func convertInt(val string, chType config.ChColumn) (interface{}, error) {
+	intType, ok := chIntTypes[chType.BaseType]
+	if !ok {
+		return nil, fmt.Errorf("%s is not an integer type", chType.BaseType)
+	}
+
+	n, err := parseInteger(val)
+	if err != nil {
+		return nil, err
+	}
+
+	min, max := new(big.Int), new(big.Int).Lsh(big.NewInt(1), intType.bits)
+	if intType.signed {
+		max.Rsh(max, 1)
+		min.Neg(max)
+	}
+	max.Sub(max, big.NewInt(1))
+
+	if n.Cmp(min) < 0 || n.Cmp(max) > 0 {
+		return nil, fmt.Errorf("%s is out of the %s range", val, chType.BaseType)
+	}
+
+	switch chType.BaseType {
+	case utils.ChInt8:
+		return int8(n.Int64()), nil
+	case utils.ChInt16:
+		return int16(n.Int64()), nil
+	case utils.ChInt32:
+		return int32(n.Int64()), nil
+	case utils.ChInt64:
+		return n.Int64(), nil
+	case utils.ChUInt8:
+		return uint8(n.Uint64()), nil
+	case utils.ChUInt16:
+		return uint16(n.Uint64()), nil
+	case utils.ChUint32:
+		return uint32(n.Uint64()), nil
+	}
+
+	return n.Uint64(), nil
+}
+
+// convertFloat converts numeric value into the Float32 or Float64 column; NaN and infinities are kept,
+// finite values beyond the range of the column type are rejected
+func convertFloat(val string, chType config.ChColumn) (interface{}, error) {
+	bitSize := 64
+	if chType.BaseType == utils.ChFloat32 {
+		bitSize = 32
+	}
+
+	f, err := strconv.ParseFloat(val, bitSize)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return nil, fmt.Errorf("%s is out of the %s range", val, chType.BaseType)
+		}
+
+		return nil, fmt.Errorf("%q is not a number", val)
+	}
+
+	if bitSize == 32 {
+		return float32(f), nil
+	}
+
+	return f, nil
+}
+
+// parseInteger parses integer or the number with zero fractional part, e.g. 10, 10.00 or 1e3
+func parseInteger(val string) (*big.Int, error) {
+	if n, ok := new(big.Int).SetString(val, 10); ok {
+		return n, nil
+	}
+
+	r, ok := new(big.Rat).SetString(val)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a number", val)
+	}
+
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%s can't be stored in the integer column without losing the fractional part", val)
+	}
+
+	return r.Num(), nil
+}
+
+// subtractNumbers returns the difference of two numbers in the text form and whether it's not zero;
+// integers and numerics are subtracted exactly, the numbers in the exponential notation as floats
+func subtractNumbers(a, b string) (string, bool, error) {
+	if strings.ContainsAny(a+b, "eEnN") { // exponent, NaN or Infinity
+		fa, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return "", false, fmt.Errorf("%q is not a number", a)
+		}
+
+		fb, err := strconv.ParseFloat(b, 64)
+		if err != nil {
+			return "", false, fmt.Errorf("%q is not a number", b)
+		}
+
+		return strconv.FormatFloat(fa-fb, 'g', -1, 64), fa-fb != 0, nil
+	}
+
+	ra, ok := new(big.Rat).SetString(a)
+	if !ok {
+		return "", false, fmt.Errorf("%q is not a number", a)
+	}
+
+	rb, ok := new(big.Rat).SetString(b)
+	if !ok {
+		return "", false, fmt.Errorf("%q is not a number", b)
+	}
+
+	scale := fractionalDigits(a)
+	if scale < fractionalDigits(b) {
+		scale = fractionalDigits(b)
+	}
+
+	diff := new(big.Rat).Sub(ra, rb)
+
+	return diff.FloatString(scale), diff.Sign() != 0, nil
+}
+
+// fractionalDigits returns the number of digits after the decimal point
+func fractionalDigits(val string) int {
+	if idx := strings.IndexByte(val, '.'); idx >= 0 {
+		return len(val) - idx - 1
+	}
+
+	return 0
+}
+
+func parseBool(val string) (bool, error) {
+	switch strings.ToLower(val) {
+	case pgTrue:
+		fallthrough
+	case "true":
+		return true, nil
+	case pgFalse:
+		fallthrough
+	case "false":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("%q is not a boolean", val)
+}
+
+// parseTimeOfDay parses time or timetz value, e.g. 15:04:05.123456+03, into microseconds since midnight;
+// utc offset is dropped, 24:00:00 is allowed
+func parseTimeOfDay(val string) (int64, error) {
+	str := val
+	if idx := strings.LastIndexAny(str, "+-"); idx > 0 {
+		str = str[:idx]
+	}
+
+	parts := strings.Split(str, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time: %q", val)
+	}
+
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid time: %q", val)
+	}
+
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time: %q", val)
+	}
+
+	seconds, err := utils.ParseDecimal(parts[2], utils.MaxDecimal64Precision, 6)
+	if err != nil || seconds.Sign() < 0 || seconds.Int64() >= 60*microsPerSecond {
+		return 0, fmt.Errorf("invalid time: %q", val)
+	}
+
+	micros := (hours*3600+minutes*60)*microsPerSecond + seconds.Int64()
+	if micros > 24*3600*microsPerSecond {
+		return 0, fmt.Errorf("invalid time: %q", val)
+	}
+
+	return micros, nil
+}
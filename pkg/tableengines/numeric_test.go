@@ -0,0 +1,141 @@
+package tableengines
+
+import (
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func TestConvertInt(t *testing.T) {
+	tests := []struct {
+		val      string
+		chType   config.ChColumn
+		expected interface{}
+		fails    bool
+	}{
+		{val: "127", chType: chColumn(utils.ChInt8), expected: int8(127)},
+		{val: "-128", chType: chColumn(utils.ChInt8), expected: int8(-128)},
+		{val: "128", chType: chColumn(utils.ChInt8), fails: true},
+		{val: "-129", chType: chColumn(utils.ChInt8), fails: true},
+		{val: "-32768", chType: chColumn(utils.ChInt16), expected: int16(-32768)},
+		{val: "2147483647", chType: chColumn(utils.ChInt32), expected: int32(2147483647)},
+		{val: "-9223372036854775808", chType: chColumn(utils.ChInt64), expected: int64(-9223372036854775808)},
+		{val: "9223372036854775808", chType: chColumn(utils.ChInt64), fails: true},
+		{val: "255", chType: chColumn(utils.ChUInt8), expected: uint8(255)},
+		{val: "256", chType: chColumn(utils.ChUInt8), fails: true},
+		{val: "-1", chType: chColumn(utils.ChUInt16), fails: true},
+		{val: "4294967295", chType: chColumn(utils.ChUint32), expected: uint32(4294967295)},
+		{val: "18446744073709551615", chType: chColumn(utils.ChUint64), expected: uint64(18446744073709551615)},
+		{val: "18446744073709551616", chType: chColumn(utils.ChUint64), fails: true},
+		{val: "10.00", chType: chColumn(utils.ChInt32), expected: int32(10)},
+		{val: "1e3", chType: chColumn(utils.ChInt32), expected: int32(1000)},
+		{val: "10.5", chType: chColumn(utils.ChInt32), fails: true},
+		{val: "NaN", chType: chColumn(utils.ChInt32), fails: true},
+		{val: "abc", chType: chColumn(utils.ChInt32), fails: true},
+		{val: "1", chType: chColumn(utils.ChFloat64), fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := convertInt(tt.val, tt.chType)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("convertInt(%q, %s): expected error, got %#v", tt.val, tt.chType.BaseType, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("convertInt(%q, %s): unexpected error: %v", tt.val, tt.chType.BaseType, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("convertInt(%q, %s) = %#v, expected %#v", tt.val, tt.chType.BaseType, res, tt.expected)
+		}
+	}
+}
+
+func TestParseInteger(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected string
+		fails    bool
+	}{
+		{str: "0", expected: "0"},
+		{str: "-42", expected: "-42"},
+		{str: "+42", expected: "42"},
+		{str: "170141183460469231731687303715884105728", expected: "170141183460469231731687303715884105728"},
+		{str: "10.000", expected: "10"},
+		{str: "-2.5e1", expected: "-25"},
+		{str: "1E2", expected: "100"},
+		{str: "0.1", fails: true},
+		{str: "1e-1", fails: true},
+		{str: "", fails: true},
+		{str: "1.2.3", fails: true},
+		{str: "Infinity", fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := parseInteger(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("parseInteger(%q): expected error, got %v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseInteger(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if res.String() != tt.expected {
+			t.Errorf("parseInteger(%q) = %v, expected %s", tt.str, res, tt.expected)
+		}
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected int64
+		fails    bool
+	}{
+		{str: "00:00:00", expected: 0},
+		{str: "15:04:05", expected: (15*3600 + 4*60 + 5) * microsPerSecond},
+		{str: "15:04:05.123456", expected: (15*3600+4*60+5)*microsPerSecond + 123456},
+		{str: "15:04:05.5", expected: (15*3600+4*60+5)*microsPerSecond + 500000},
+		{str: "15:04:05+03", expected: (15*3600 + 4*60 + 5) * microsPerSecond},
+		{str: "15:04:05.000001-05:30", expected: (15*3600+4*60+5)*microsPerSecond + 1},
+		{str: "24:00:00", expected: 24 * 3600 * microsPerSecond},
+		{str: "24:00:00+00", expected: 24 * 3600 * microsPerSecond},
+		{str: "24:00:01", fails: true},
+		{str: "25:00:00", fails: true},
+		{str: "12:60:00", fails: true},
+		{str: "12:00:60", fails: true},
+		{str: "12:00", fails: true},
+		{str: "12:00:00:00", fails: true},
+		{str: "ab:00:00", fails: true},
+		{str: "", fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := parseTimeOfDay(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("parseTimeOfDay(%q): expected error, got %d", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseTimeOfDay(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("parseTimeOfDay(%q) = %d, expected %d", tt.str, res, tt.expected)
+		}
+	}
+}
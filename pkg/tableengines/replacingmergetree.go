@@ -31,7 +31,7 @@ func NewReplacingMergeTree(ctx context.Context, conn *sql.DB, tblCfg config.Tabl
 	t.chUsedColumns = append(t.chUsedColumns, tblCfg.IsDeletedColumn)
 
 	t.flushQueries = []string{fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[3]s ORDER BY %[4]s",
-		t.cfg.ChMainTable, strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable, t.cfg.BufferTableRowIdColumn)}
+		t.mainTable(), strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable, t.cfg.BufferTableRowIdColumn)}
 
 	return &t
 }
@@ -49,6 +49,10 @@ func (t *replacingMergeTree) Write(p []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+
+	if row == nil { // skipped according to the null policy
+		return n, nil
+	}
 	if t.cfg.GenerationColumn != "" {
 		row = append(row, 0) // "generationID"
 	}
@@ -62,47 +66,82 @@ func (t *replacingMergeTree) Write(p []byte) (int, error) {
 
 // Insert handles incoming insert DML operation
 func (t *replacingMergeTree) Insert(lsn utils.LSN, new message.Row) (bool, error) {
-	if t.cfg.VerColumn != "" {
-		return t.processCommandSet(commandSet{append(t.convertTuples(new), uint64(lsn), 0)})
-	} else {
-		return t.processCommandSet(commandSet{append(t.convertTuples(new), 0)})
+	cmd, err := t.tupleCommand(new, t.serviceValues(lsn, 0)...)
+	if err != nil {
+		return false, err
 	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
 }
 
-// Update handles incoming update DML operation
+// Update handles incoming update DML operation; only the key of the old row is needed
 func (t *replacingMergeTree) Update(lsn utils.LSN, old, new message.Row) (bool, error) {
-	var cmdSet commandSet
+	old, err := t.restoreOldRow(old, new)
+	if err != nil {
+		return false, err
+	}
+	new = fillUnchanged(old, new)
+
+	cmdSet := make(commandSet, 0, 2)
 	equal, keyChanged := t.compareRows(old, new)
 	if equal {
 		return t.processCommandSet(nil)
 	}
 
 	if keyChanged {
-		if t.cfg.VerColumn != "" {
-			cmdSet = commandSet{
-				append(t.convertTuples(old), uint64(lsn), 1),
-				append(t.convertTuples(new), uint64(lsn), 0),
-			}
-		} else {
-			cmdSet = commandSet{
-				append(t.convertTuples(old), 1),
-				append(t.convertTuples(new), 0),
-			}
+		cmd, err := t.keyTupleCommand(old, t.serviceValues(lsn, 1)...)
+		if err != nil {
+			return false, err
 		}
-	} else if t.cfg.VerColumn != "" {
-		cmdSet = commandSet{append(t.convertTuples(new), uint64(lsn), 0)}
-	} else {
-		cmdSet = commandSet{append(t.convertTuples(new), 0)}
+		cmdSet = append(cmdSet, cmd)
+
+		if err := t.forgetRow(old); err != nil {
+			return false, err
+		}
+	}
+
+	cmd, err := t.tupleCommand(new, t.serviceValues(lsn, 0)...)
+	if err != nil {
+		return false, err
+	}
+	cmdSet = append(cmdSet, cmd)
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
 	}
 
 	return t.processCommandSet(cmdSet)
 }
 
-// Delete handles incoming delete DML operation
+// Delete handles incoming delete DML operation; only the key of the old row is needed,
+// the row is replaced by the tombstone with the deleted flag set
 func (t *replacingMergeTree) Delete(lsn utils.LSN, old message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, nil)
+	if err != nil {
+		return false, err
+	}
+
+	cmd, err := t.keyTupleCommand(old, t.serviceValues(lsn, 1)...)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.forgetRow(old); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// serviceValues returns values of the version, if used, and is_deleted columns
+func (t *replacingMergeTree) serviceValues(lsn utils.LSN, isDeleted int) []interface{} {
 	if t.cfg.VerColumn != "" {
-		return t.processCommandSet(commandSet{append(t.convertTuples(old), uint64(lsn), 0)})
-	} else {
-		return t.processCommandSet(commandSet{append(t.convertTuples(old), 0)})
+		return []interface{}{uint64(lsn), isDeleted}
 	}
+
+	return []interface{}{isDeleted}
 }
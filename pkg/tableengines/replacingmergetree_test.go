@@ -0,0 +1,51 @@
+package tableengines
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/message"
+)
+
+func TestReplacingUpdate(t *testing.T) {
+	cfg := testTableConfig()
+	cfg.VerColumn = "ver"
+	cfg.IsDeletedColumn = "is_deleted"
+	for i := range cfg.TupleColumns { // full replica identity
+		cfg.TupleColumns[i].IsKey = true
+	}
+
+	tests := []struct {
+		new      message.Row
+		expected []bufRow
+	}{
+		{
+			new: message.Row{text("1"), text("us"), text("10")},
+			expected: []bufRow{
+				{rowID: 0, data: []interface{}{int32(1), "us", int64(10), uint64(100), 0}},
+			},
+		},
+		{
+			new: message.Row{text("2"), text("eu"), text("10")},
+			expected: []bufRow{
+				{rowID: 0, data: []interface{}{int32(1), "eu", int64(10), uint64(100), 1}},
+				{rowID: 1, data: []interface{}{int32(2), "eu", int64(10), uint64(100), 0}},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		tbl := NewReplacingMergeTree(context.Background(), nil, cfg, new(uint64))
+
+		old := message.Row{text("1"), text("eu"), text("10")}
+		if _, err := tbl.Update(100, old, tt.new); err != nil {
+			t.Errorf("#%d Update(): unexpected error: %v", i, err)
+			continue
+		}
+
+		if tbl.bufferCmdId != 1 || !reflect.DeepEqual(tbl.buffer[0], bufCommand(tt.expected)) {
+			t.Errorf("#%d Update() buffered %#v, expected %#v", i, tbl.buffer[:tbl.bufferCmdId], tt.expected)
+		}
+	}
+}
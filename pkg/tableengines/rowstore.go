@@ -0,0 +1,362 @@
+package tableengines
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/peterbourgon/diskv"
+
+	"github.com/mkabilov/pg2ch/pkg/message"
+)
+
+const rowStoreCacheSize = 16 * 1024 * 1024 // 16MB
+
+// rowStore keeps the last known image of every row on disk by the row key
+type rowStore struct {
+	storage *diskv.Diskv
+	staged  map[string]*rowImage // [file key]image changed since the last commit, nil if erased
+	erased  bool                 // whether all the stored images are erased since the last commit
+}
+
+// rowImage contains values of the replicated columns and the version the row was written with
+type rowImage struct {
+	version uint64
+	values  map[string]message.Tuple
+}
+
+func newRowStore(path string) *rowStore {
+	return &rowStore{
+		storage: diskv.New(diskv.Options{
+			BasePath:     path,
+			Transform:    func(key string) []string { return []string{key[:2], key[2:4]} },
+			CacheSizeMax: rowStoreCacheSize,
+		}),
+		staged: make(map[string]*rowImage),
+	}
+}
+
+func (s *rowStore) get(key string) (rowImage, bool, error) {
+	fileKey := rowStoreKey(key)
+	if image, ok := s.staged[fileKey]; ok {
+		if image == nil {
+			return rowImage{}, false, nil
+		}
+
+		return *image, true, nil
+	}
+
+	if s.erased || !s.storage.Has(fileKey) {
+		return rowImage{}, false, nil
+	}
+
+	data, err := s.storage.Read(fileKey)
+	if err != nil {
+		return rowImage{}, false, err
+	}
+
+	image, err := decodeRowImage(data)
+	if err != nil {
+		return rowImage{}, false, err
+	}
+
+	return image, true, nil
+}
+
+// set stages the image till the commit
+func (s *rowStore) set(key string, image rowImage) {
+	s.staged[rowStoreKey(key)] = &image
+}
+
+// erase stages erasing of the image till the commit
+func (s *rowStore) erase(key string) {
+	s.staged[rowStoreKey(key)] = nil
+}
+
+// eraseAll stages erasing of all the images till the commit
+func (s *rowStore) eraseAll() {
+	s.staged = make(map[string]*rowImage)
+	s.erased = true
+}
+
+// commit writes the staged changes, called along with storing the lsn of the flushed changes
+func (s *rowStore) commit() error {
+	if s.erased {
+		if err := s.storage.EraseAll(); err != nil {
+			return err
+		}
+		s.erased = false
+	}
+
+	for fileKey, image := range s.staged {
+		if image == nil {
+			if s.storage.Has(fileKey) {
+				if err := s.storage.Erase(fileKey); err != nil {
+					return err
+				}
+			}
+		} else {
+			data, err := encodeRowImage(*image)
+			if err != nil {
+				return err
+			}
+
+			if err := s.storage.Write(fileKey, data); err != nil {
+				return err
+			}
+		}
+		delete(s.staged, fileKey)
+	}
+
+	return nil
+}
+
+// write stores the image right away, used by the initial sync
+func (s *rowStore) write(key string, image rowImage) error {
+	data, err := encodeRowImage(image)
+	if err != nil {
+		return err
+	}
+
+	return s.storage.Write(rowStoreKey(key), data)
+}
+
+// reset erases all the images right away along with the staged changes
+func (s *rowStore) reset() error {
+	s.staged = make(map[string]*rowImage)
+	s.erased = false
+
+	return s.storage.EraseAll()
+}
+
+// rowStoreKey turns the row key into the file name
+func rowStoreKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeRowImage encodes the image as the version followed by the column names, value kinds and values
+func encodeRowImage(image rowImage) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.BigEndian, image.version); err != nil {
+		return nil, fmt.Errorf("could not encode row image: %v", err)
+	}
+
+	for name, tuple := range image.values {
+		if len(name) > math.MaxUint16 || uint64(len(tuple.Value)) > math.MaxUint32 {
+			return nil, fmt.Errorf("could not encode row image: %q column is too long", name)
+		}
+
+		if err := binary.Write(buf, binary.BigEndian, uint16(len(name))); err != nil {
+			return nil, fmt.Errorf("could not encode row image: %v", err)
+		}
+		buf.WriteString(name)
+		buf.WriteByte(byte(tuple.Kind))
+
+		if err := binary.Write(buf, binary.BigEndian, uint32(len(tuple.Value))); err != nil {
+			return nil, fmt.Errorf("could not encode row image: %v", err)
+		}
+		buf.Write(tuple.Value)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeRowImage(data []byte) (rowImage, error) {
+	image := rowImage{values: make(map[string]message.Tuple)}
+	buf := bytes.NewReader(data)
+	if err := binary.Read(buf, binary.BigEndian, &image.version); err != nil {
+		return image, fmt.Errorf("could not decode row image: %v", err)
+	}
+
+	for buf.Len() > 0 {
+		var (
+			nameLen  uint16
+			valueLen uint32
+		)
+
+		if err := binary.Read(buf, binary.BigEndian, &nameLen); err != nil {
+			return image, fmt.Errorf("could not decode row image: %v", err)
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(buf, name); err != nil {
+			return image, fmt.Errorf("could not decode row image: %v", err)
+		}
+
+		kind, err := buf.ReadByte()
+		if err != nil {
+			return image, fmt.Errorf("could not decode row image: %v", err)
+		}
+
+		if err := binary.Read(buf, binary.BigEndian, &valueLen); err != nil {
+			return image, fmt.Errorf("could not decode row image: %v", err)
+		}
+		value := make([]byte, valueLen)
+		if _, err := io.ReadFull(buf, value); err != nil {
+			return image, fmt.Errorf("could not decode row image: %v", err)
+		}
+
+		image.values[string(name)] = message.Tuple{Kind: message.TupleKind(kind), Value: value}
+	}
+
+	return image, nil
+}
+
+// restoreOldRow fills the old row with the values postgresql didn't send from the row image store
+func (t *genericTable) restoreOldRow(old, new message.Row) (message.Row, error) {
+	src := old
+	if src == nil {
+		src = new
+	}
+
+	res := make(message.Row, len(t.tupleColumns))
+	for colId, col := range t.tupleColumns {
+		if col.IsKey && colId < len(src) {
+			res[colId] = src[colId]
+		} else {
+			res[colId] = message.Tuple{Kind: message.TupleUnchanged, Value: []byte{}}
+		}
+	}
+
+	if t.rowStore == nil {
+		return res, nil
+	}
+
+	key, ok := t.rowKey(res)
+	if !ok {
+		return res, nil
+	}
+
+	image, ok, err := t.rowStore.get(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not get row image: %v", err)
+	} else if !ok {
+		return res, nil
+	}
+
+	for colId, col := range t.tupleColumns {
+		if tuple, ok := image.values[col.Name]; ok && res[colId].Kind == message.TupleUnchanged {
+			res[colId] = tuple
+		}
+	}
+
+	return res, nil
+}
+
+// rememberRow stages the image of the inserted or updated row, unchanged values are kept from the previous image
+func (t *genericTable) rememberRow(row message.Row, version uint64) error {
+	if t.rowStore == nil {
+		return nil
+	}
+
+	key, image, err := t.rowImage(row, version)
+	if err != nil {
+		return err
+	}
+	t.rowStore.set(key, image)
+
+	return nil
+}
+
+// rowImage returns the key and the image of the row
+func (t *genericTable) rowImage(row message.Row, version uint64) (string, rowImage, error) {
+	key, ok := t.rowKey(row)
+	if !ok {
+		return "", rowImage{}, fmt.Errorf("row has no key values")
+	}
+
+	image := rowImage{version: version, values: make(map[string]message.Tuple)}
+	var prevImage *rowImage
+	for colId, col := range t.tupleColumns {
+		if !t.usesColumn(col.Name) && !col.IsKey {
+			continue
+		}
+
+		if row[colId].Kind != message.TupleUnchanged {
+			image.values[col.Name] = row[colId]
+			continue
+		}
+
+		if prevImage == nil {
+			prev, _, err := t.rowStore.get(key)
+			if err != nil {
+				return "", rowImage{}, fmt.Errorf("could not get row image: %v", err)
+			}
+			prevImage = &prev
+		}
+
+		if tuple, ok := prevImage.values[col.Name]; ok {
+			image.values[col.Name] = tuple
+		}
+	}
+
+	return key, image, nil
+}
+
+// rememberSyncRow stores the image of the row copied during the initial sync
+func (t *genericTable) rememberSyncRow(fields []sql.NullString) error {
+	if t.rowStore == nil {
+		return nil
+	}
+
+	values := make(map[string]sql.NullString, len(fields))
+	for i, field := range fields {
+		values[t.pgUsedColumns[i]] = field
+	}
+
+	row := make(message.Row, len(t.tupleColumns))
+	for colId, col := range t.tupleColumns {
+		field, ok := values[col.Name]
+		switch {
+		case !ok:
+			row[colId] = message.Tuple{Kind: message.TupleUnchanged, Value: []byte{}}
+		case field.Valid:
+			row[colId] = message.Tuple{Kind: message.TupleText, Value: []byte(field.String)}
+		default:
+			row[colId] = message.Tuple{Kind: message.TupleNull, Value: []byte{}}
+		}
+	}
+
+	key, image, err := t.rowImage(row, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := t.rowStore.write(key, image); err != nil {
+		return fmt.Errorf("could not store row image: %v", err)
+	}
+
+	return nil
+}
+
+// forgetRow stages erasing of the image of the deleted row
+func (t *genericTable) forgetRow(row message.Row) error {
+	if t.rowStore == nil {
+		return nil
+	}
+
+	if key, ok := t.rowKey(row); ok {
+		t.rowStore.erase(key)
+	}
+
+	return nil
+}
+
+// commitRowImages writes the staged row images once the changes they belong to are flushed
+func (t *genericTable) commitRowImages() error {
+	if t.rowStore == nil {
+		return nil
+	}
+
+	if err := t.rowStore.commit(); err != nil {
+		return fmt.Errorf("could not commit row images: %v", err)
+	}
+
+	return nil
+}
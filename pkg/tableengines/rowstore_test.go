@@ -0,0 +1,105 @@
+package tableengines
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/message"
+)
+
+func TestRowImageEncoding(t *testing.T) {
+	image := rowImage{
+		version: 42,
+		values: map[string]message.Tuple{
+			"id":   {Kind: message.TupleText, Value: []byte("1")},
+			"name": {Kind: message.TupleNull, Value: []byte{}},
+			"doc":  {Kind: message.TupleText, Value: []byte(`{"a": 1}`)},
+		},
+	}
+
+	data, err := encodeRowImage(image)
+	if err != nil {
+		t.Fatalf("encodeRowImage: unexpected error: %v", err)
+	}
+
+	res, err := decodeRowImage(data)
+	if err != nil {
+		t.Fatalf("decodeRowImage: unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(res, image) {
+		t.Errorf("decodeRowImage(encodeRowImage(%#v)) = %#v", image, res)
+	}
+
+	if _, err := decodeRowImage(data[:len(data)-1]); err == nil {
+		t.Errorf("decodeRowImage: expected error for the truncated image")
+	}
+}
+
+func TestRowStoreCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rowstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := func(version uint64) rowImage {
+		return rowImage{version: version, values: map[string]message.Tuple{}}
+	}
+
+	s := newRowStore(dir)
+	if err := s.write("a", image(1)); err != nil {
+		t.Fatalf("write: unexpected error: %v", err)
+	}
+
+	s.set("a", image(2))
+	s.set("b", image(3))
+
+	// staged images are seen by the store, but not written until the commit
+	if res, ok, err := s.get("a"); err != nil || !ok || res.version != 2 {
+		t.Errorf("get(a) before commit = %v, %v, %v, expected version 2", res, ok, err)
+	}
+	if res, ok, err := newRowStore(dir).get("a"); err != nil || !ok || res.version != 1 {
+		t.Errorf("stored get(a) before commit = %v, %v, %v, expected version 1", res, ok, err)
+	}
+	if _, ok, _ := newRowStore(dir).get("b"); ok {
+		t.Errorf("stored get(b) before commit: expected no image")
+	}
+
+	s.erase("a")
+	if _, ok, _ := s.get("a"); ok {
+		t.Errorf("get(a) after erase: expected no image")
+	}
+
+	if err := s.commit(); err != nil {
+		t.Fatalf("commit: unexpected error: %v", err)
+	}
+
+	stored := newRowStore(dir)
+	if _, ok, _ := stored.get("a"); ok {
+		t.Errorf("stored get(a) after commit: expected no image")
+	}
+	if res, ok, err := stored.get("b"); err != nil || !ok || res.version != 3 {
+		t.Errorf("stored get(b) after commit = %v, %v, %v, expected version 3", res, ok, err)
+	}
+
+	s.eraseAll()
+	s.set("c", image(4))
+	if _, ok, _ := s.get("b"); ok {
+		t.Errorf("get(b) after eraseAll: expected no image")
+	}
+
+	if err := s.commit(); err != nil {
+		t.Fatalf("commit: unexpected error: %v", err)
+	}
+
+	stored = newRowStore(dir)
+	if _, ok, _ := stored.get("b"); ok {
+		t.Errorf("stored get(b) after eraseAll commit: expected no image")
+	}
+	if res, ok, err := stored.get("c"); err != nil || !ok || res.version != 4 {
+		t.Errorf("stored get(c) after eraseAll commit = %v, %v, %v, expected version 4", res, ok, err)
+	}
+}
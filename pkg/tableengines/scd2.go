@@ -0,0 +1,189 @@
+package tableengines
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// precision of the valid_from and valid_to columns, the one of the postgresql commit timestamps
+const scd2TimePrecision = 6
+
+// validity of the current versions of the rows
+var scd2ValidForever = utils.TruncateTime(utils.ChTimeRanges[utils.ChDateTime64][1], scd2TimePrecision)
+
+// scd2Table keeps every version of the row with the commit times of the transactions which opened and closed it
+type scd2Table struct {
+	genericTable
+
+	signColumn string
+}
+
+// NewSCD2 instantiates scd2Table
+func NewSCD2(ctx context.Context, conn *sql.DB, tblCfg config.Table, genID *uint64) *scd2Table {
+	t := scd2Table{
+		genericTable: newGenericTable(ctx, conn, tblCfg, genID),
+		signColumn:   tblCfg.SignColumn,
+	}
+	t.chUsedColumns = append(t.chUsedColumns,
+		tblCfg.ValidFromColumn, tblCfg.ValidToColumn, tblCfg.IsCurrentColumn, tblCfg.SignColumn)
+
+	// rows are collapsed by the is_current version, so the order of the insertion doesn't matter
+	t.flushQueries = []string{fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[3]s",
+		t.mainTable(), strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable)}
+
+	return &t
+}
+
+// Sync performs initial sync of the data; pgTx is a transaction in which temporary replication slot is created
+func (t *scd2Table) Sync(pgTx *pgx.Tx) error {
+	return t.genSync(pgTx, t)
+}
+
+// Write implements io.Writer which is used during the Sync process, see genSync method
+func (t *scd2Table) Write(p []byte) (int, error) {
+	var row []interface{}
+
+	row, n, err := t.syncConvertIntoRow(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if row == nil { // skipped according to the null policy
+		return n, nil
+	}
+
+	if t.cfg.GenerationColumn != "" {
+		row = append(row, 0) // generationID
+	}
+	row = append(row, time.Unix(0, 0).UTC(), scd2ValidForever, uint8(1), 1) // validity, is_current and sign
+
+	return n, t.insertRow(row)
+}
+
+// Insert handles incoming insert DML operation
+func (t *scd2Table) Insert(lsn utils.LSN, new message.Row) (bool, error) {
+	commitTime := t.commitTime()
+
+	cmd, err := t.tupleCommand(new, commitTime, scd2ValidForever, uint8(1), 1)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.rememberRow(new, uint64(commitTime.UnixNano())); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// Update handles incoming update DML operation: the current version of the row is closed and the new one is opened
+func (t *scd2Table) Update(lsn utils.LSN, old, new message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, new)
+	if err != nil {
+		return false, err
+	}
+	new = fillUnchanged(old, new)
+
+	equal, keyChanged := t.compareRows(old, new)
+	if equal {
+		return t.processCommandSet(nil)
+	}
+
+	commitTime := t.commitTime()
+
+	cmdSet, err := t.closeCommands(old, commitTime)
+	if err != nil {
+		return false, err
+	}
+
+	newCmd, err := t.tupleCommand(new, commitTime, scd2ValidForever, uint8(1), 1)
+	if err != nil {
+		return false, err
+	}
+
+	if keyChanged {
+		if err := t.forgetRow(old); err != nil {
+			return false, err
+		}
+	}
+
+	if err := t.rememberRow(new, uint64(commitTime.UnixNano())); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(append(cmdSet, newCmd))
+}
+
+// Delete handles incoming delete DML operation: the current version of the row is closed by the commit time
+func (t *scd2Table) Delete(lsn utils.LSN, old message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, nil)
+	if err != nil {
+		return false, err
+	}
+
+	cmdSet, err := t.closeCommands(old, t.commitTime())
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.forgetRow(old); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(cmdSet)
+}
+
+// closeCommands returns the commands cancelling the current version of the row and writing it closed
+func (t *scd2Table) closeCommands(old message.Row, commitTime time.Time) (commandSet, error) {
+	validFrom, err := t.validFrom(old)
+	if err != nil {
+		return nil, fmt.Errorf("could not get validity of the old row: %v", err)
+	}
+
+	cancelCmd, err := t.tupleCommand(old, validFrom, scd2ValidForever, uint8(1), -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if !validFrom.Before(commitTime) {
+		return commandSet{cancelCmd}, nil
+	}
+
+	closedCmd, err := t.tupleCommand(old, validFrom, commitTime, uint8(0), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return commandSet{cancelCmd, closedCmd}, nil
+}
+
+// commitTime returns the commit time of the transaction being replicated
+func (t *scd2Table) commitTime() time.Time {
+	return utils.TruncateTime(t.txBegin.Timestamp.UTC(), scd2TimePrecision)
+}
+
+// validFrom returns the time the current version of the row is valid since
+func (t *scd2Table) validFrom(row message.Row) (time.Time, error) {
+	key, ok := t.rowKey(row)
+	if !ok {
+		return time.Time{}, fmt.Errorf("row has no key values")
+	}
+
+	image, ok, err := t.rowStore.get(key)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not get row image: %v", err)
+	} else if !ok {
+		return time.Time{}, fmt.Errorf("row is not found in the row image store")
+	}
+
+	return time.Unix(0, int64(image.version)).UTC(), nil
+}
@@ -0,0 +1,69 @@
+package tableengines
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/message"
+)
+
+func TestSCD2Commands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scd2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := testTableConfig()
+	cfg.SignColumn = "sign"
+	cfg.ValidFromColumn = "valid_from"
+	cfg.ValidToColumn = "valid_to"
+	cfg.IsCurrentColumn = "is_current"
+	cfg.RowImageStore = true
+	cfg.RowImageStorePath = dir
+
+	tbl := NewSCD2(context.Background(), nil, cfg, new(uint64))
+
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 123456000, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	tbl.SetTransaction(message.Begin{Timestamp: t1})
+	if _, err := tbl.Insert(10, message.Row{text("1"), text("eu"), text("5")}); err != nil {
+		t.Fatalf("Insert(): unexpected error: %v", err)
+	}
+
+	// the version opened by the same transaction is only cancelled
+	if _, err := tbl.Update(10, nil, message.Row{text("1"), text("eu"), text("6")}); err != nil {
+		t.Fatalf("Update(): unexpected error: %v", err)
+	}
+
+	tbl.SetTransaction(message.Begin{Timestamp: t2})
+	if _, err := tbl.Update(20, nil, message.Row{text("1"), text("us"), unchangedTuple}); err != nil {
+		t.Fatalf("Update(): unexpected error: %v", err)
+	}
+
+	expected := []bufCommand{
+		{{rowID: 0, data: []interface{}{int32(1), "eu", int64(5), t1, scd2ValidForever, uint8(1), 1}}},
+		{
+			{rowID: 1, data: []interface{}{int32(1), "eu", int64(5), t1, scd2ValidForever, uint8(1), -1}},
+			{rowID: 2, data: []interface{}{int32(1), "eu", int64(6), t1, scd2ValidForever, uint8(1), 1}},
+		},
+		{
+			{rowID: 3, data: []interface{}{int32(1), "eu", int64(6), t1, scd2ValidForever, uint8(1), -1}},
+			{rowID: 4, data: []interface{}{int32(1), "eu", int64(6), t1, t2, uint8(0), 1}},
+			{rowID: 5, data: []interface{}{int32(1), "us", int64(6), t2, scd2ValidForever, uint8(1), 1}},
+		},
+	}
+
+	if !reflect.DeepEqual(tbl.buffer[:tbl.bufferCmdId], expected) {
+		t.Errorf("buffered %#v, expected %#v", tbl.buffer[:tbl.bufferCmdId], expected)
+	}
+
+	if _, err := tbl.Delete(30, message.Row{text("2"), unchangedTuple, unchangedTuple}); err == nil {
+		t.Errorf("Delete(): expected error for the row missing in the row image store")
+	}
+}
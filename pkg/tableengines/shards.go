@@ -0,0 +1,358 @@
+package tableengines
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/lib/cityhash102"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// shard is the cluster shard the rows of the table are written into directly, bypassing the distributed table;
+// the shards written or flushed by the failed attempt are skipped by the retries, so the rows are not duplicated
+type shard struct {
+	conn  *sql.DB
+	tx    *sql.Tx
+	stmnt *sql.Stmt
+
+	written bool // rows of the current memory buffer are committed into the shard
+	flushed bool // buffer table of the shard is flushed into the main table
+}
+
+// SetShards sets connections to the shards of the cluster and their weights, the rows are routed to them
+// by the sharding columns the same way the distributed table does
+func (t *genericTable) SetShards(conns []*sql.DB, weights []uint64) {
+	t.shards = make([]*shard, len(conns))
+	t.shardSlots = make([]uint64, len(conns))
+	for i, conn := range conns {
+		t.shards[i] = &shard{conn: conn}
+		t.shardSlots[i] = weights[i]
+		if i > 0 {
+			t.shardSlots[i] += t.shardSlots[i-1]
+		}
+	}
+}
+
+// shardOf returns the shard of the row given the values of the clickhouse columns
+func (t *genericTable) shardOf(values []interface{}) (*shard, error) {
+	keyValues := make([]interface{}, len(t.shardColumns))
+	for i, idx := range t.shardColumns {
+		keyValues[i] = values[idx]
+	}
+
+	return t.shardByKey(keyValues)
+}
+
+// shardByKey returns the shard by the cityHash64 of the sharding column values modulo the total weight of the shards
+func (t *genericTable) shardByKey(keyValues []interface{}) (*shard, error) {
+	var hash uint64
+
+	for i, val := range keyValues {
+		valHash, err := cityHash64(val, t.columnMapping[t.cfg.ShardBy[i]])
+		if err != nil {
+			return nil, fmt.Errorf("could not hash value of the %q sharding column: %v", t.cfg.ShardBy[i], err)
+		}
+
+		if i == 0 {
+			hash = valHash
+		} else {
+			hash = hash128to64(hash, valHash)
+		}
+	}
+
+	slot := hash % t.shardSlots[len(t.shardSlots)-1]
+	for i, maxSlot := range t.shardSlots {
+		if slot < maxSlot {
+			return t.shards[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("could not find shard of the %d slot", slot)
+}
+
+// cityHash64 returns the clickhouse cityHash64 of the value of the column: numbers, dates and times
+// are hashed by intHash64 of their bits, strings by the CityHash64 v1.0.2
+func cityHash64(val interface{}, chType config.ChColumn) (uint64, error) {
+	switch v := val.(type) {
+	case int8:
+		return intHash64(uint64(uint8(v))), nil
+	case int16:
+		return intHash64(uint64(uint16(v))), nil
+	case int32:
+		return intHash64(uint64(uint32(v))), nil
+	case int64:
+		return intHash64(uint64(v)), nil
+	case uint8:
+		return intHash64(uint64(v)), nil
+	case uint16:
+		return intHash64(uint64(v)), nil
+	case uint32:
+		return intHash64(uint64(v)), nil
+	case uint64:
+		return intHash64(v), nil
+	case float32:
+		return intHash64(uint64(math.Float32bits(v))), nil
+	case float64:
+		return intHash64(math.Float64bits(v)), nil
+	case time.Time:
+		if chType.BaseType == utils.ChDate {
+			_, offset := v.Zone() // the way the driver writes the dates
+			return intHash64(uint64(uint16((v.Unix() + int64(offset)) / 86400))), nil
+		}
+
+		if v.IsZero() {
+			return intHash64(0), nil
+		}
+
+		return intHash64(uint64(uint32(v.Unix()))), nil
+	case string:
+		return stringHash64([]byte(v), chType), nil
+	case []byte:
+		return stringHash64(v, chType), nil
+	}
+
+	return 0, fmt.Errorf("%T values can't be hashed", val)
+}
+
+// stringHash64 returns CityHash64 of the string, FixedString values are padded with zero bytes
+func stringHash64(val []byte, chType config.ChColumn) uint64 {
+	if chType.BaseType == utils.ChFixedString && len(chType.Ext) > 0 && len(val) < chType.Ext[0] {
+		padded := make([]byte, chType.Ext[0])
+		copy(padded, val)
+		val = padded
+	}
+
+	return cityhash102.CityHash64(val, uint32(len(val)))
+}
+
+// intHash64 is the clickhouse intHash64 function
+func intHash64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// hash128to64 combines the hashes of the cityHash64 arguments
+func hash128to64(low, high uint64) uint64 {
+	const kMul = 0x9ddfea08eb382d69
+
+	a := (low ^ high) * kMul
+	a ^= a >> 47
+	b := (high ^ a) * kMul
+	b ^= b >> 47
+
+	return b * kMul
+}
+
+// rowShard returns the shard the replicated row is written into
+func (t *genericTable) rowShard(row message.Row) (*shard, error) {
+	keyValues := make([]interface{}, 0, len(t.cfg.ShardBy))
+	for _, pgColName := range t.cfg.ShardBy {
+		var (
+			vals []interface{}
+			err  error
+		)
+
+		colId := t.tupleColumnIndex(pgColName)
+		if colId < 0 || colId >= len(row) {
+			return nil, fmt.Errorf("could not find %q sharding column", pgColName)
+		}
+
+		switch row[colId].Kind {
+		case message.TupleNull:
+			vals, err = t.nullColumn(pgColName)
+		case message.TupleUnchanged:
+			return nil, fmt.Errorf("no value of the %q sharding column", pgColName)
+		default:
+			vals, err = t.convertColumn(pgColName, string(row[colId].Value))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keyValues = append(keyValues, vals[0])
+	}
+
+	return t.shardByKey(keyValues)
+}
+
+// lookupConn returns connection the row is looked up by: the connection to the shard of the row
+// if the table is written to the shards directly
+func (t *genericTable) lookupConn(row message.Row) (*sql.DB, error) {
+	if t.shards == nil {
+		return t.chConn, nil
+	}
+
+	s, err := t.rowShard(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not find shard of the row: %v", err)
+	}
+
+	return s.conn, nil
+}
+
+func (t *genericTable) tupleColumnIndex(pgColName string) int {
+	for colId, col := range t.tupleColumns {
+		if col.Name == pgColName {
+			return colId
+		}
+	}
+
+	return -1
+}
+
+func (t *genericTable) beginShards() error {
+	for i, s := range t.shards {
+		if s.written {
+			continue
+		}
+
+		tx, err := s.conn.Begin()
+		if err != nil {
+			t.rollbackShards()
+			return fmt.Errorf("could not begin transaction of the shard #%d: %v", i+1, err)
+		}
+		s.tx = tx
+	}
+
+	return nil
+}
+
+func (t *genericTable) prepareShards(query string) error {
+	for i, s := range t.shards {
+		if s.written {
+			continue
+		}
+
+		stmnt, err := s.tx.Prepare(query)
+		if err != nil {
+			t.rollbackShards()
+			return fmt.Errorf("could not prepare statement of the shard #%d: %v", i+1, err)
+		}
+		s.stmnt = stmnt
+	}
+
+	return nil
+}
+
+// execShard inserts the row into its shard unless the shard got the rows by the previous attempt
+func (t *genericTable) execShard(params []interface{}) error {
+	s, err := t.shardOf(params)
+	if err != nil {
+		t.rollbackShards()
+		return err
+	}
+
+	if s.written {
+		return nil
+	}
+
+	if _, err := s.stmnt.Exec(params...); err != nil {
+		t.rollbackShards()
+		return err
+	}
+
+	return nil
+}
+
+func (t *genericTable) closeCommitShards() error {
+	for i, s := range t.shards {
+		if s.written {
+			continue
+		}
+
+		if err := s.stmnt.Close(); err != nil {
+			t.rollbackShards()
+			return fmt.Errorf("could not close statement of the shard #%d: %v", i+1, err)
+		}
+		s.stmnt = nil
+
+		if err := s.tx.Commit(); err != nil {
+			t.rollbackShards()
+			return fmt.Errorf("could not commit transaction of the shard #%d: %v", i+1, err)
+		}
+		s.tx = nil
+		s.written = true
+	}
+
+	for _, s := range t.shards {
+		s.written = false
+	}
+
+	return nil
+}
+
+// rollbackShards closes the statements and rolls back the transactions of the shards not written yet,
+// so the retry writes the rows into them again
+func (t *genericTable) rollbackShards() {
+	for i, s := range t.shards {
+		if s.stmnt != nil {
+			if err := s.stmnt.Close(); err != nil {
+				log.Printf("could not close statement of the shard #%d: %v", i+1, err)
+			}
+			s.stmnt = nil
+		}
+
+		if s.tx != nil {
+			if err := s.tx.Rollback(); err != nil {
+				log.Printf("could not rollback transaction of the shard #%d: %v", i+1, err)
+			}
+			s.tx = nil
+		}
+	}
+}
+
+// resetShards forgets which shards got the rows or were flushed by the failed attempts once retrying is given up
+func (t *genericTable) resetShards() {
+	for _, s := range t.shards {
+		s.written = false
+		s.flushed = false
+	}
+}
+
+// flushShardsToMainTable flushes the buffer tables of the shards into the main tables,
+// the buffer table of the shard is truncated right after the flush
+func (t *genericTable) flushShardsToMainTable() error {
+	for i, s := range t.shards {
+		if s.flushed {
+			continue
+		}
+
+		for _, query := range t.flushQueries {
+			if _, err := s.conn.Exec(query); err != nil {
+				return fmt.Errorf("could not flush shard #%d: %v", i+1, err)
+			}
+		}
+
+		if _, err := s.conn.Exec(fmt.Sprintf("truncate table %s", t.cfg.ChBufferTable)); err != nil {
+			return fmt.Errorf("could not truncate buffer table of the shard #%d: %v", i+1, err)
+		}
+		s.flushed = true
+	}
+
+	for _, s := range t.shards {
+		s.flushed = false
+	}
+
+	return nil
+}
+
+func (t *genericTable) truncateShardBufTables() error {
+	for i, s := range t.shards {
+		if _, err := s.conn.Exec(fmt.Sprintf("truncate table %s", t.cfg.ChBufferTable)); err != nil {
+			return fmt.Errorf("could not truncate buffer table of the shard #%d: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package tableengines
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func TestCityHash64(t *testing.T) {
+	tests := []struct {
+		val      interface{}
+		chType   config.ChColumn
+		expected uint64
+		fails    bool
+	}{
+		{val: "", chType: chColumn(utils.ChString), expected: 11160318154034397263},
+		{val: []byte{}, chType: chColumn(utils.ChString), expected: 11160318154034397263},
+		{val: uint64(1), chType: chColumn(utils.ChUint64), expected: 0xb456bcfc34c2cb2c},
+		{val: int8(1), chType: chColumn(utils.ChInt8), expected: 0xb456bcfc34c2cb2c},
+		{val: int8(-1), chType: chColumn(utils.ChInt8), expected: intHash64(0xff)},
+		{val: int64(-1), chType: chColumn(utils.ChInt64), expected: intHash64(0xffffffffffffffff)},
+		{val: float32(1), chType: chColumn(utils.ChFloat32), expected: intHash64(0x3f800000)},
+		{val: time.Date(1970, 1, 3, 12, 0, 0, 0, time.UTC), chType: chColumn(utils.ChDate), expected: intHash64(2)},
+		{val: time.Unix(100, 0), chType: chColumn(utils.ChDateTime), expected: intHash64(100)},
+		{val: "ab", chType: chColumn(utils.ChFixedString, 4),
+			expected: stringHash64([]byte("ab\x00\x00"), chColumn(utils.ChString))},
+		{val: true, chType: chColumn(utils.ChUInt8), fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := cityHash64(tt.val, tt.chType)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("cityHash64(%#v): expected error, got %d", tt.val, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("cityHash64(%#v): unexpected error: %v", tt.val, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("cityHash64(%#v) = %d, expected %d", tt.val, res, tt.expected)
+		}
+	}
+}
+
+func TestShardByKey(t *testing.T) {
+	cfg := testTableConfig()
+	cfg.ShardBy = []string{"id", "region"}
+
+	tbl := newGenericTable(context.Background(), nil, cfg, new(uint64))
+	tbl.SetShards([]*sql.DB{nil, nil, nil}, []uint64{1, 0, 3})
+
+	counts := make(map[*shard]int)
+	for i := int32(0); i < 4000; i++ {
+		s, err := tbl.shardByKey([]interface{}{i, "eu"})
+		if err != nil {
+			t.Fatalf("shardByKey(): unexpected error: %v", err)
+		}
+		counts[s]++
+
+		hash := hash128to64(intHash64(uint64(uint32(i))), stringHash64([]byte("eu"), chColumn(utils.ChString)))
+		expected := tbl.shards[0]
+		if hash%4 >= 1 {
+			expected = tbl.shards[2]
+		}
+
+		if s != expected {
+			t.Fatalf("shardByKey(%d) is not the shard the distributed table routes the row to", i)
+		}
+	}
+
+	if counts[tbl.shards[1]] != 0 {
+		t.Errorf("%d rows are routed to the shard of zero weight", counts[tbl.shards[1]])
+	}
+
+	if counts[tbl.shards[0]] < 800 || counts[tbl.shards[0]] > 1200 {
+		t.Errorf("%d of 4000 rows are routed to the shard of 1/4 weight", counts[tbl.shards[0]])
+	}
+}
@@ -0,0 +1,284 @@
+package tableengines
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// summingMergeTreeTable stores the deltas of the measure columns grouped by the dimension columns,
+// used for both SummingMergeTree and AggregatingMergeTree engines
+type summingMergeTreeTable struct {
+	genericTable
+
+	dimensions map[string]struct{}
+	measures   map[string]struct{}
+}
+
+// NewSummingMergeTree instantiates summingMergeTreeTable
+func NewSummingMergeTree(ctx context.Context, conn *sql.DB, tblCfg config.Table, genID *uint64) *summingMergeTreeTable {
+	t := summingMergeTreeTable{
+		genericTable: newGenericTable(ctx, conn, tblCfg, genID),
+		dimensions:   make(map[string]struct{}),
+		measures:     make(map[string]struct{}),
+	}
+
+	for _, pgColName := range tblCfg.Dimensions {
+		t.dimensions[pgColName] = struct{}{}
+	}
+	for _, pgColName := range tblCfg.Measures {
+		t.measures[pgColName] = struct{}{}
+	}
+
+	// deltas are summed up, so the order of the insertion doesn't matter
+	if tblCfg.Engine == config.AggregatingMergeTree {
+		t.flushQueries = []string{t.aggregateFlushQuery()}
+	} else {
+		t.flushQueries = []string{fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[3]s",
+			t.mainTable(), strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable)}
+	}
+
+	return &t
+}
+
+// aggregateFlushQuery returns the query turning the deltas of the buffer table into the sum states of the main table
+func (t *summingMergeTreeTable) aggregateFlushQuery() string {
+	measureColumns := make(map[string]struct{}, len(t.measures))
+	for pgColName := range t.measures {
+		measureColumns[t.columnMapping[pgColName].Name] = struct{}{}
+	}
+
+	selectColumns := make([]string, 0, len(t.chUsedColumns))
+	groupBy := make([]string, 0, len(t.chUsedColumns))
+	for _, chColName := range t.chUsedColumns {
+		if _, ok := measureColumns[chColName]; ok {
+			selectColumns = append(selectColumns, fmt.Sprintf("sumState(%s)", chColName))
+		} else if chColName == t.cfg.GenerationColumn {
+			selectColumns = append(selectColumns, fmt.Sprintf("max(%s)", chColName))
+		} else {
+			selectColumns = append(selectColumns, chColName)
+			groupBy = append(groupBy, chColName)
+		}
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", t.mainTable(),
+		strings.Join(t.chUsedColumns, ", "), strings.Join(selectColumns, ", "), t.cfg.ChBufferTable)
+	if len(groupBy) > 0 {
+		query += fmt.Sprintf(" GROUP BY %s", strings.Join(groupBy, ", "))
+	}
+
+	return query
+}
+
+// Sync performs initial sync of the data; pgTx is a transaction in which temporary replication slot is created
+func (t *summingMergeTreeTable) Sync(pgTx *pgx.Tx) error {
+	return t.genSync(pgTx, t)
+}
+
+// Write implements io.Writer which is used during the Sync process, see genSync method;
+// values of the copied rows are the deltas from zero
+func (t *summingMergeTreeTable) Write(p []byte) (int, error) {
+	var row []interface{}
+
+	row, n, err := t.syncConvertIntoRow(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if row == nil { // skipped according to the null policy
+		return n, nil
+	}
+
+	if t.cfg.GenerationColumn != "" {
+		row = append(row, 0) // generationID
+	}
+
+	return n, t.insertRow(row)
+}
+
+// Insert handles incoming insert DML operation
+func (t *summingMergeTreeTable) Insert(lsn utils.LSN, new message.Row) (bool, error) {
+	cmd, err := t.deltaCommand(new, new, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// Update handles incoming update DML operation: the measures of the new row minus the ones of the old row
+// are added to the dimensions of the row; if the dimensions changed, the old row is subtracted from the old ones
+func (t *summingMergeTreeTable) Update(lsn utils.LSN, old, new message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, new)
+	if err != nil {
+		return false, err
+	}
+	new = fillUnchanged(old, new)
+
+	equal, keyChanged := t.compareRows(old, new)
+	if equal {
+		return t.processCommandSet(nil)
+	}
+
+	cmdSet := make(commandSet, 0, 2)
+	if t.dimensionsChanged(old, new) {
+		oldCmd, err := t.deltaCommand(old, nil, old)
+		if err != nil {
+			return false, err
+		}
+
+		newCmd, err := t.deltaCommand(new, new, nil)
+		if err != nil {
+			return false, err
+		}
+		cmdSet = append(cmdSet, oldCmd, newCmd)
+	} else {
+		cmd, err := t.deltaCommand(new, new, old)
+		if err != nil {
+			return false, err
+		}
+		cmdSet = append(cmdSet, cmd)
+	}
+
+	if keyChanged {
+		if err := t.forgetRow(old); err != nil {
+			return false, err
+		}
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(cmdSet)
+}
+
+// Delete handles incoming delete DML operation: the measures of the old row are subtracted
+func (t *summingMergeTreeTable) Delete(lsn utils.LSN, old message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, nil)
+	if err != nil {
+		return false, err
+	}
+
+	cmd, err := t.deltaCommand(old, nil, old)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.forgetRow(old); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// deltaCommand returns the values of the dimensions taken from the dims row and the measures equal to
+// the differences between the plus and minus rows, either of which might be nil;
+// returns nil if all the differences are zero or the row is to be skipped
+func (t *summingMergeTreeTable) deltaCommand(dims, plus, minus message.Row) ([]interface{}, error) {
+	res := make([]interface{}, 0)
+	nonZero := false
+
+	for colId, col := range t.tupleColumns {
+		var (
+			vals []interface{}
+			err  error
+		)
+
+		if !t.usesColumn(col.Name) {
+			continue
+		}
+
+		if _, ok := t.measures[col.Name]; ok {
+			vals, err = t.measureDelta(col.Name, measureValue(plus, colId), measureValue(minus, colId), &nonZero)
+		} else {
+			switch dims[colId].Kind {
+			case message.TupleNull:
+				vals, err = t.nullColumn(col.Name)
+			case message.TupleUnchanged:
+				vals, err = t.unchangedColumn(dims, colId)
+			default:
+				vals, err = t.convertColumn(col.Name, string(dims[colId].Value))
+			}
+		}
+		if err == errSkipRow {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not convert row of the %s table: %v", t.cfg.PgTableName.String(), err)
+		}
+
+		res = append(res, vals...)
+	}
+
+	if !nonZero {
+		return nil, nil
+	}
+
+	if t.cfg.GenerationColumn != "" {
+		res = append(res, uint32(*t.generationID))
+	}
+
+	return res, nil
+}
+
+// measureDelta converts the difference of the measure values, nonZero is set if the difference is not zero
+func (t *summingMergeTreeTable) measureDelta(pgColName string, plus, minus message.Tuple,
+	nonZero *bool) ([]interface{}, error) {
+	if plus.Kind == message.TupleUnchanged || minus.Kind == message.TupleUnchanged {
+		return nil, fmt.Errorf("value of the %q measure was not sent by postgresql and is not found in the old row "+
+			"or row image store", pgColName)
+	}
+
+	delta, ok, err := subtractNumbers(numberOrZero(plus), numberOrZero(minus))
+	if err != nil {
+		return nil, fmt.Errorf("could not subtract values of the %q measure: %v", pgColName, err)
+	}
+	*nonZero = *nonZero || ok
+
+	return t.convertColumn(pgColName, delta)
+}
+
+// dimensionsChanged checks if any of the dimension values differ in the rows
+func (t *summingMergeTreeTable) dimensionsChanged(old, new message.Row) bool {
+	for colId, col := range t.tupleColumns {
+		if _, ok := t.dimensions[col.Name]; !ok || new[colId].Kind == message.TupleUnchanged {
+			continue
+		}
+
+		if old[colId].Kind != new[colId].Kind || !bytes.Equal(old[colId].Value, new[colId].Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// measureValue returns the value of the measure column in the row, null one if there's no row
+func measureValue(row message.Row, colId int) message.Tuple {
+	if row == nil {
+		return message.Tuple{Kind: message.TupleNull}
+	}
+
+	return row[colId]
+}
+
+// numberOrZero returns the number in the text form, nulls are treated as zeros
+func numberOrZero(tuple message.Tuple) string {
+	if tuple.Kind == message.TupleNull {
+		return "0"
+	}
+
+	return string(tuple.Value)
+}
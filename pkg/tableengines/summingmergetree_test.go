@@ -0,0 +1,79 @@
+package tableengines
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+)
+
+func newTestSummingTable(setup func(*config.Table)) *summingMergeTreeTable {
+	cfg := testTableConfig()
+	cfg.Engine = config.SummingMergeTree
+	cfg.Dimensions = []string{"region"}
+	cfg.Measures = []string{"amount"}
+	delete(cfg.ColumnMapping, "id")
+	if setup != nil {
+		setup(&cfg)
+	}
+
+	return NewSummingMergeTree(context.Background(), nil, cfg, new(uint64))
+}
+
+func TestSummingDeltaCommand(t *testing.T) {
+	tbl := newTestSummingTable(nil)
+
+	old := message.Row{text("1"), text("eu"), text("10")}
+	tests := []struct {
+		dims     message.Row
+		plus     message.Row
+		minus    message.Row
+		expected []interface{}
+	}{
+		{dims: old, plus: old, expected: []interface{}{"eu", int64(10)}},
+		{dims: old, minus: old, expected: []interface{}{"eu", int64(-10)}},
+		{dims: old, plus: message.Row{text("1"), text("eu"), text("25")}, minus: old,
+			expected: []interface{}{"eu", int64(15)}},
+		{dims: old, plus: message.Row{text("1"), text("eu"), nullTuple}, minus: old,
+			expected: []interface{}{"eu", int64(-10)}},
+		{dims: old, plus: old, minus: old}, // zero delta
+		{dims: message.Row{text("1"), nullTuple, text("10")}, plus: message.Row{text("1"), nullTuple, text("10")},
+			expected: []interface{}{nil, int64(10)}},
+	}
+
+	for i, tt := range tests {
+		res, err := tbl.deltaCommand(tt.dims, tt.plus, tt.minus)
+		if err != nil {
+			t.Errorf("#%d deltaCommand(): unexpected error: %v", i, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("#%d deltaCommand() = %#v, expected %#v", i, res, tt.expected)
+		}
+	}
+
+	if res, err := tbl.deltaCommand(old, message.Row{text("1"), text("eu"), unchangedTuple}, old); err == nil {
+		t.Errorf("deltaCommand(): expected error for the unchanged measure, got %#v", res)
+	}
+}
+
+func TestSummingFlushQueries(t *testing.T) {
+	tbl := newTestSummingTable(nil)
+	expected := []string{"INSERT INTO sales (region, amount) SELECT region, amount FROM sales_buf"}
+	if !reflect.DeepEqual(tbl.flushQueries, expected) {
+		t.Errorf("SummingMergeTree flush queries = %q, expected %q", tbl.flushQueries, expected)
+	}
+
+	tbl = newTestSummingTable(func(cfg *config.Table) {
+		cfg.Engine = config.AggregatingMergeTree
+		cfg.GenerationColumn = "gen"
+	})
+	expected = []string{"INSERT INTO sales (region, amount, gen) SELECT region, sumState(amount), max(gen) " +
+		"FROM sales_buf GROUP BY region"}
+	if !reflect.DeepEqual(tbl.flushQueries, expected) {
+		t.Errorf("AggregatingMergeTree flush queries = %q, expected %q", tbl.flushQueries, expected)
+	}
+}
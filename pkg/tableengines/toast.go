@@ -0,0 +1,366 @@
+package tableengines
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+)
+
+// toastCache is the lru cache of the rows last seen by the replicator
+type toastCache struct {
+	size  int
+	order *list.List // from the most to the least recently used
+	rows  map[string]*list.Element
+}
+
+type toastCacheItem struct {
+	key string
+	row message.Row
+}
+
+func newToastCache(size int) *toastCache {
+	return &toastCache{
+		size:  size,
+		order: list.New(),
+		rows:  make(map[string]*list.Element),
+	}
+}
+
+func (c *toastCache) get(key string) (message.Row, bool) {
+	el, ok := c.rows[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+
+	return el.Value.(*toastCacheItem).row, true
+}
+
+func (c *toastCache) set(key string, row message.Row) {
+	if el, ok := c.rows[key]; ok {
+		el.Value.(*toastCacheItem).row = row
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.rows[key] = c.order.PushFront(&toastCacheItem{key: key, row: row})
+	if c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.rows, el.Value.(*toastCacheItem).key)
+	}
+}
+
+func (c *toastCache) reset() {
+	c.order.Init()
+	c.rows = make(map[string]*list.Element)
+}
+
+// fillUnchanged takes the unchanged toasted values from the old row, sent under the full replica identity
+func fillUnchanged(old, new message.Row) message.Row {
+	res := make(message.Row, len(new))
+	copy(res, new)
+
+	for colId, tuple := range new {
+		if tuple.Kind == message.TupleUnchanged && colId < len(old) && old[colId].Kind == message.TupleText {
+			res[colId] = old[colId]
+		}
+	}
+
+	return res
+}
+
+// unchangedColumn resolves the unchanged toasted value by the toast fallbacks
+func (t *genericTable) unchangedColumn(row message.Row, colId int) ([]interface{}, error) {
+	pgColName := t.tupleColumns[colId].Name
+
+	for _, fallback := range t.cfg.ToastFallback {
+		switch fallback {
+		case config.ToastFallbackCache:
+			key, ok := t.rowKey(row)
+			if !ok {
+				continue
+			}
+
+			cached, ok := t.toastCache.get(key)
+			if !ok || colId >= len(cached) || cached[colId].Kind == message.TupleUnchanged {
+				continue
+			}
+
+			row[colId] = cached[colId]
+			if cached[colId].Kind == message.TupleNull {
+				return t.nullColumn(pgColName)
+			}
+
+			return t.convertColumn(pgColName, string(cached[colId].Value))
+		case config.ToastFallbackClickHouse:
+			vals, err := t.lookupColumn(row, pgColName)
+			if err != nil {
+				return nil, fmt.Errorf("could not look up unchanged value of the %q column: %v", pgColName, err)
+			}
+
+			if vals != nil {
+				return vals, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("value of the %q column was not sent by postgresql and is not found in the old row, "+
+		"row image store or by the toast fallback", pgColName)
+}
+
+// cacheRow puts the row into the toast cache if the cache is used
+func (t *genericTable) cacheRow(row message.Row) {
+	if t.toastCache == nil {
+		return
+	}
+
+	key, ok := t.rowKey(row)
+	if !ok {
+		return
+	}
+
+	cached := make(message.Row, len(row))
+	for colId, tuple := range row { // values refer to the buffer of the replication message
+		cached[colId] = message.Tuple{Kind: tuple.Kind, Value: append([]byte(nil), tuple.Value...)}
+	}
+
+	t.toastCache.set(key, cached)
+}
+
+// keyColumns returns indexes of the replica identity columns, or of the primary key ones for the full identity
+func (t *genericTable) keyColumns() []int {
+	pkColumns, identityColumns := make([]int, 0), make([]int, 0)
+	for colId, col := range t.tupleColumns {
+		if t.cfg.PgColumns[col.Name].PkCol > 0 {
+			pkColumns = append(pkColumns, colId)
+		}
+		if col.IsKey {
+			identityColumns = append(identityColumns, colId)
+		}
+	}
+
+	if len(identityColumns) == len(t.tupleColumns) && len(pkColumns) > 0 {
+		return pkColumns
+	}
+
+	return identityColumns
+}
+
+// rowKey returns the key values of the row joined into a string
+func (t *genericTable) rowKey(row message.Row) (string, bool) {
+	key := &strings.Builder{}
+	for _, colId := range t.keyColumns() {
+		if colId >= len(row) || row[colId].Kind != message.TupleText {
+			return "", false
+		}
+		fmt.Fprintf(key, "%d:%s", len(row[colId].Value), row[colId].Value)
+	}
+
+	return key.String(), key.Len() > 0
+}
+
+// lookupColumn fetches the column values of the latest version of the row from clickhouse
+func (t *genericTable) lookupColumn(row message.Row, pgColName string) ([]interface{}, error) {
+	conditions, args, err := t.lookupConditions(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.flushMemoryBuffer(); err != nil {
+		return nil, err
+	}
+
+	conn, err := t.lookupConn(row)
+	if err != nil {
+		return nil, err
+	}
+
+	chCols := t.pgColumnChColumns(pgColName)
+	chColumns := make([]string, 0, len(chCols))
+	for _, chCol := range chCols {
+		chColumns = append(chColumns, chCol.Name)
+	}
+	columns := strings.Join(chColumns, ", ")
+	where := strings.Join(conditions, " AND ")
+
+	queries := make([]string, 0, 2)
+	if t.cfg.ChBufferTable != "" {
+		queries = append(queries, fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s DESC LIMIT 1",
+			columns, t.cfg.ChBufferTable, where, t.cfg.BufferTableRowIdColumn))
+	}
+
+	final := ""
+	if t.cfg.Engine != config.MergeTree {
+		final = " FINAL"
+	}
+	queries = append(queries, fmt.Sprintf("SELECT %s FROM %s%s WHERE %s LIMIT 1", columns, t.mainTable(), final, where))
+
+	for _, query := range queries {
+		vals := make([]interface{}, len(chCols))
+		dest := make([]interface{}, len(vals))
+		for i := range vals {
+			dest[i] = &vals[i]
+		}
+
+		err := conn.QueryRow(query, args...).Scan(dest...)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not query %q: %v", query, err)
+		}
+
+		for i, chCol := range chCols {
+			if vals[i], err = t.lookupValue(vals[i], chCol, pgColName); err != nil {
+				return nil, fmt.Errorf("could not read value of the %q column: %v", chCol.Name, err)
+			}
+		}
+
+		return vals, nil
+	}
+
+	return nil, nil
+}
+
+// lookupValue casts the value scanned from clickhouse into the go type the converters produce for the column,
+// as the driver returns e.g. ip addresses and arrays of them in its own types
+func (t *genericTable) lookupValue(val interface{}, chCol config.ChColumn, pgColName string) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+
+	goType, err := chGoType(chCol, t.cfg.PgColumns[pgColName])
+	if err != nil {
+		return nil, err
+	}
+
+	if chCol.IsArray {
+		if chCol.IsNullable && goType.Kind() != reflect.Ptr {
+			goType = reflect.PtrTo(goType)
+		}
+		for i := 0; i < chCol.ArrayDepth || i == 0; i++ {
+			goType = reflect.SliceOf(goType)
+		}
+	}
+
+	res, err := castScannedValue(reflect.ValueOf(val), goType)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Interface(), nil
+}
+
+// castScannedValue casts the value scanned from clickhouse into the target type element by element
+func castScannedValue(val reflect.Value, targetType reflect.Type) (reflect.Value, error) {
+	if val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return reflect.Zero(targetType), nil
+		}
+		val = val.Elem()
+	}
+
+	switch {
+	case targetType.Kind() == reflect.Ptr:
+		elem, err := castScannedValue(val, targetType.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		res := reflect.New(targetType.Elem())
+		res.Elem().Set(elem)
+
+		return res, nil
+	case targetType.Kind() == reflect.Slice && targetType.Elem().Kind() != reflect.Uint8:
+		if val.Kind() != reflect.Slice {
+			return reflect.Value{}, fmt.Errorf("can't cast %v into %v", val.Type(), targetType)
+		}
+
+		res := reflect.MakeSlice(targetType, val.Len(), val.Len())
+		for i := 0; i < val.Len(); i++ {
+			elem, err := castScannedValue(val.Index(i), targetType.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			res.Index(i).Set(elem)
+		}
+
+		return res, nil
+	case val.Kind() == targetType.Kind() && val.Type().ConvertibleTo(targetType):
+		return val.Convert(targetType), nil
+	}
+
+	return castValue(val, targetType)
+}
+
+// lookupConditions returns conditions for looking up the current version of the row by its key
+func (t *genericTable) lookupConditions(row message.Row) ([]string, []interface{}, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, colId := range t.keyColumns() {
+		keyCol := t.tupleColumns[colId]
+
+		chCol, ok := t.columnMapping[keyCol.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("key column %q is not replicated", keyCol.Name)
+		}
+
+		if row[colId].Kind != message.TupleText {
+			return nil, nil, fmt.Errorf("no value of the %q key column", keyCol.Name)
+		}
+
+		vals, err := t.convertColumn(keyCol.Name, string(row[colId].Value))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		conditions = append(conditions, fmt.Sprintf("%s = ?", chCol.Name))
+		args = append(args, vals[0])
+	}
+
+	if len(conditions) == 0 {
+		return nil, nil, fmt.Errorf("table has neither primary key nor replica identity columns")
+	}
+
+	if t.cfg.SignColumn != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = 1", t.cfg.SignColumn))
+	}
+
+	if t.cfg.IsCurrentColumn != "" {
+		conditions = append(conditions, fmt.Sprintf("%s = 1", t.cfg.IsCurrentColumn))
+	}
+
+	// tombstones of the deleted rows keep no values
+	if t.cfg.Engine == config.ReplacingMergeTree {
+		conditions = append(conditions, fmt.Sprintf("%s = 0", t.cfg.IsDeletedColumn))
+	}
+
+	return conditions, args, nil
+}
+
+// flushMemoryBuffer flushes the memory buffer before looking up rows in clickhouse
+func (t *genericTable) flushMemoryBuffer() error {
+	t.flushMutex.Lock()
+	defer t.flushMutex.Unlock()
+
+	if err := t.flushBuffer(); err != nil {
+		return fmt.Errorf("could not flush buffer: %v", err)
+	}
+
+	return nil
+}
+
+// pgColumnChColumns returns clickhouse columns of the postgresql column in the order of convertColumn values
+func (t *genericTable) pgColumnChColumns(pgColName string) []config.ChColumn {
+	chCols := t.mappedChColumns(pgColName)
+	for _, extraction := range t.jsonExtractions[pgColName] {
+		chCols = append(chCols, extraction.ChColumn)
+	}
+
+	return chCols
+}
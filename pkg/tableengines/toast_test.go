@@ -0,0 +1,91 @@
+package tableengines
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+// ipv4 is the type the driver scans IPv4 array elements into
+type ipv4 net.IP
+
+func TestLookupValue(t *testing.T) {
+	tbl := newGenericTable(context.Background(), nil, testTableConfig(), new(uint64))
+
+	nullableUInt8 := chColumn(utils.ChUInt8)
+	nullableUInt8.IsNullable = true
+
+	arrayIPv4 := chColumn(utils.ChIPv4)
+	arrayIPv4.IsArray, arrayIPv4.ArrayDepth = true, 1
+
+	arrayNullableInt16 := chColumn(utils.ChInt16)
+	arrayNullableInt16.IsArray, arrayNullableInt16.IsNullable, arrayNullableInt16.ArrayDepth = true, true, 1
+
+	one := int16(1)
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	tests := []struct {
+		val      interface{}
+		chType   config.ChColumn
+		expected interface{}
+		fails    bool
+	}{
+		{val: nil, chType: nullableUInt8, expected: nil},
+		{val: uint8(1), chType: nullableUInt8, expected: uint8(1)},
+		{val: int32(12346), chType: chColumn(utils.ChDecimal, 9, 2), expected: int32(12346)},
+		{val: ts, chType: chColumn(utils.ChDateTime64, 6), expected: ts},
+		{val: "paid", chType: chColumn(utils.ChEnum8), expected: "paid"},
+		{val: []ipv4{ipv4(net.IPv4(10, 0, 0, 1))}, chType: arrayIPv4, expected: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{val: []*int16{&one, nil}, chType: arrayNullableInt16, expected: []*int16{&one, nil}},
+		{val: int64(1), chType: chColumn(utils.ChString), fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := tbl.lookupValue(tt.val, tt.chType, "id")
+		if tt.fails {
+			if err == nil {
+				t.Errorf("lookupValue(%#v): expected error, got %#v", tt.val, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("lookupValue(%#v): unexpected error: %v", tt.val, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("lookupValue(%#v) = %#v, expected %#v", tt.val, res, tt.expected)
+		}
+	}
+}
+
+func TestLookupConditions(t *testing.T) {
+	cfg := testTableConfig()
+	cfg.Engine = config.ReplacingMergeTree
+	cfg.IsDeletedColumn = "is_deleted"
+	tbl := newGenericTable(context.Background(), nil, cfg, new(uint64))
+
+	conditions, args, err := tbl.lookupConditions(message.Row{text("1"), text("eu"), unchangedTuple})
+	if err != nil {
+		t.Fatalf("lookupConditions(): unexpected error: %v", err)
+	}
+
+	if expected := []string{"id = ?", "is_deleted = 0"}; !reflect.DeepEqual(conditions, expected) {
+		t.Errorf("lookupConditions() = %q, expected %q", conditions, expected)
+	}
+
+	if expected := []interface{}{int32(1)}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("lookupConditions() args = %#v, expected %#v", args, expected)
+	}
+
+	if _, _, err := tbl.lookupConditions(message.Row{nullTuple, text("eu"), unchangedTuple}); err == nil {
+		t.Errorf("expected error for the row without the key value")
+	}
+}
@@ -0,0 +1,160 @@
+package tableengines
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/message"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+type versionedCollapsingMergeTreeTable struct {
+	genericTable
+
+	signColumn string
+	verColumn  string
+}
+
+// NewVersionedCollapsingMergeTree instantiates versionedCollapsingMergeTreeTable
+func NewVersionedCollapsingMergeTree(ctx context.Context, conn *sql.DB, tblCfg config.Table,
+	genID *uint64) *versionedCollapsingMergeTreeTable {
+	t := versionedCollapsingMergeTreeTable{
+		genericTable: newGenericTable(ctx, conn, tblCfg, genID),
+		signColumn:   tblCfg.SignColumn,
+		verColumn:    tblCfg.VerColumn,
+	}
+	t.chUsedColumns = append(t.chUsedColumns, tblCfg.SignColumn, tblCfg.VerColumn)
+
+	// rows are collapsed by the version, so the order of the insertion doesn't matter
+	t.flushQueries = []string{fmt.Sprintf("INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM %[3]s",
+		t.mainTable(), strings.Join(t.chUsedColumns, ", "), t.cfg.ChBufferTable)}
+
+	return &t
+}
+
+// Sync performs initial sync of the data; pgTx is a transaction in which temporary replication slot is created
+func (t *versionedCollapsingMergeTreeTable) Sync(pgTx *pgx.Tx) error {
+	return t.genSync(pgTx, t)
+}
+
+// Write implements io.Writer which is used during the Sync process, see genSync method
+func (t *versionedCollapsingMergeTreeTable) Write(p []byte) (int, error) {
+	var row []interface{}
+
+	row, n, err := t.syncConvertIntoRow(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if row == nil { // skipped according to the null policy
+		return n, nil
+	}
+
+	if t.cfg.GenerationColumn != "" {
+		row = append(row, 0) // generationID
+	}
+	row = append(row, 1, uint64(0)) // sign and version
+
+	return n, t.insertRow(row)
+}
+
+// Insert handles incoming insert DML operation
+func (t *versionedCollapsingMergeTreeTable) Insert(lsn utils.LSN, new message.Row) (bool, error) {
+	cmd, err := t.tupleCommand(new, 1, uint64(lsn))
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// Update handles incoming update DML operation: the old state of the row is cancelled with its own version
+func (t *versionedCollapsingMergeTreeTable) Update(lsn utils.LSN, old, new message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, new)
+	if err != nil {
+		return false, err
+	}
+	new = fillUnchanged(old, new)
+
+	equal, keyChanged := t.compareRows(old, new)
+	if equal {
+		return t.processCommandSet(nil)
+	}
+
+	oldVersion, err := t.rowVersion(old)
+	if err != nil {
+		return false, fmt.Errorf("could not get version of the old row: %v", err)
+	}
+
+	oldCmd, err := t.tupleCommand(old, -1, oldVersion)
+	if err != nil {
+		return false, err
+	}
+
+	newCmd, err := t.tupleCommand(new, 1, uint64(lsn))
+	if err != nil {
+		return false, err
+	}
+
+	if keyChanged {
+		if err := t.forgetRow(old); err != nil {
+			return false, err
+		}
+	}
+
+	if err := t.rememberRow(new, uint64(lsn)); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{oldCmd, newCmd})
+}
+
+// Delete handles incoming delete DML operation
+func (t *versionedCollapsingMergeTreeTable) Delete(lsn utils.LSN, old message.Row) (bool, error) {
+	old, err := t.restoreOldRow(old, nil)
+	if err != nil {
+		return false, err
+	}
+
+	version, err := t.rowVersion(old)
+	if err != nil {
+		return false, fmt.Errorf("could not get version of the deleted row: %v", err)
+	}
+
+	cmd, err := t.tupleCommand(old, -1, version)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.forgetRow(old); err != nil {
+		return false, err
+	}
+
+	return t.processCommandSet(commandSet{cmd})
+}
+
+// rowVersion returns the version the current state of the row was written with, zero for the synced rows
+func (t *versionedCollapsingMergeTreeTable) rowVersion(row message.Row) (uint64, error) {
+	key, ok := t.rowKey(row)
+	if !ok {
+		return 0, fmt.Errorf("row has no key values")
+	}
+
+	image, ok, err := t.rowStore.get(key)
+	if err != nil {
+		return 0, fmt.Errorf("could not get row image: %v", err)
+	} else if !ok {
+		return 0, fmt.Errorf("row is not found in the row image store")
+	}
+
+	return image.version, nil
+}
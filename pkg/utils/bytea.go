@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const byteaHexPrefix = `\x`
+
+// DecodeBytea decodes postgresql's bytea text representation in either hex (\x0102) or escape (\001\002) format
+func DecodeBytea(val string) ([]byte, error) {
+	if strings.HasPrefix(val, byteaHexPrefix) {
+		res, err := hex.DecodeString(val[len(byteaHexPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex bytea: %v", err)
+		}
+
+		return res, nil
+	}
+
+	res := make([]byte, 0, len(val))
+	for i, n := 0, len(val); i < n; i++ {
+		if val[i] != '\\' {
+			res = append(res, val[i])
+			continue
+		}
+
+		if i+1 < n && val[i+1] == '\\' {
+			res = append(res, '\\')
+			i++
+			continue
+		}
+
+		if i+3 >= n {
+			return nil, fmt.Errorf("invalid escape bytea: unexpected end of input")
+		}
+
+		var b byte
+		for _, ch := range []byte(val[i+1 : i+4]) {
+			digit, ok := decodeOctDigit(ch)
+			if !ok {
+				return nil, fmt.Errorf("invalid escape bytea: %q", val[i:i+4])
+			}
+			b = b<<3 + digit
+		}
+		res = append(res, b)
+		i += 3
+	}
+
+	return res, nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeBytea(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected []byte
+		fails    bool
+	}{
+		{str: `\x`, expected: []byte{}},
+		{str: `\x00ff10`, expected: []byte{0x00, 0xff, 0x10}},
+		{str: `\xDEADbeef`, expected: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{str: ``, expected: []byte{}},
+		{str: `abc`, expected: []byte("abc")},
+		{str: `a\\b`, expected: []byte(`a\b`)},
+		{str: `\000\001\377`, expected: []byte{0, 1, 0xff}},
+		{str: `x\012y`, expected: []byte("x\ny")},
+		{str: `\x0`, fails: true},
+		{str: `\xzz`, fails: true},
+		{str: `\01`, fails: true},
+		{str: `\018`, fails: true},
+		{str: `a\`, fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := DecodeBytea(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("DecodeBytea(%q): expected error, got %v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("DecodeBytea(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if !bytes.Equal(res, tt.expected) {
+			t.Errorf("DecodeBytea(%q) = %v, expected %v", tt.str, res, tt.expected)
+		}
+	}
+}
@@ -2,11 +2,43 @@ package chutils
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/mkabilov/pg2ch/pkg/config"
 	"github.com/mkabilov/pg2ch/pkg/utils"
 )
 
+// postgresql stores timestamps with microsecond precision
+const defaultTimestampPrecision = 6
+
+// base types of the columns the driver can write into
+var writableChTypes = map[string]struct{}{
+	utils.ChInt8:        {},
+	utils.ChInt16:       {},
+	utils.ChInt32:       {},
+	utils.ChInt64:       {},
+	utils.ChUInt8:       {},
+	utils.ChUInt16:      {},
+	utils.ChUint32:      {},
+	utils.ChUint64:      {},
+	utils.ChFloat32:     {},
+	utils.ChFloat64:     {},
+	utils.ChFixedString: {},
+	utils.ChString:      {},
+	utils.ChUUID:        {},
+	utils.ChDate:        {},
+	utils.ChDateTime:    {},
+	utils.ChDateTime64:  {},
+	utils.ChDecimal:     {},
+	utils.ChEnum8:       {},
+	utils.ChEnum16:      {},
+	utils.ChIPv4:        {},
+	utils.ChIPv6:        {},
+}
+
 var pgToChMap = map[string]string{
 	utils.PgSmallint:                 utils.ChInt16,
 	utils.PgInteger:                  utils.ChInt32,
@@ -16,7 +48,7 @@ var pgToChMap = map[string]string{
 	utils.PgText:                     utils.ChString,
 	utils.PgReal:                     utils.ChFloat32,
 	utils.PgDoublePrecision:          utils.ChFloat64,
-	utils.PgInterval:                 utils.ChInt32,
+	utils.PgInterval:                 utils.ChInt64,
 	utils.PgBoolean:                  utils.ChUInt8,
 	utils.PgDecimal:                  utils.ChDecimal,
 	utils.PgNumeric:                  utils.ChDecimal,
@@ -26,23 +58,31 @@ var pgToChMap = map[string]string{
 	utils.PgJson:                     utils.ChString,
 	utils.PgUuid:                     utils.ChUUID,
 	utils.PgBytea:                    utils.ChUInt8Array,
-	utils.PgInet:                     utils.ChInt64,
-	utils.PgTimestamp:                utils.ChDateTime,
-	utils.PgTimestampWithTimeZone:    utils.ChDateTime,
-	utils.PgTimestampWithoutTimeZone: utils.ChDateTime,
+	utils.PgInet:                     utils.ChIPv6,
+	utils.PgCidr:                     utils.ChIPv6,
+	utils.PgMacAddr:                  utils.ChString,
+	utils.PgMacAddr8:                 utils.ChString,
+	utils.PgHstore:                   utils.ChString,
+	utils.PgTimestamp:                utils.ChDateTime64,
+	utils.PgTimestampWithTimeZone:    utils.ChDateTime64,
+	utils.PgTimestampWithoutTimeZone: utils.ChDateTime64,
 	utils.PgDate:                     utils.ChDate,
 	utils.PgTime:                     utils.ChUint32,
 	utils.PgTimeWithoutTimeZone:      utils.ChUint32,
 	utils.PgTimeWithTimeZone:         utils.ChUint32,
 }
 
-// ToClickHouseType converts pg type into clickhouse type
-func ToClickHouseType(pgColumn config.PgColumn) (string, error) {
+// ToClickHouseType converts pg type into clickhouse type according to the column settings
+func ToClickHouseType(pgColumn config.PgColumn, colCfg config.ColumnConfig) (string, error) {
 	chType, ok := pgToChMap[pgColumn.BaseType]
 	if !ok {
 		chType = utils.ChString
 	}
 
+	if pgColumn.EnumValues != nil {
+		chType = EnumType(pgColumn.EnumValues)
+	}
+
 	switch pgColumn.BaseType {
 	case utils.PgDecimal:
 		fallthrough
@@ -50,6 +90,10 @@ func ToClickHouseType(pgColumn config.PgColumn) (string, error) {
 		if pgColumn.Ext == nil {
 			return "", fmt.Errorf("precision must be specified for the numeric type")
 		}
+		if pgColumn.Ext[0] > utils.MaxDecimal128Precision {
+			return "", fmt.Errorf("numeric precision %d is beyond %d, the max one of Decimal128", pgColumn.Ext[0],
+				utils.MaxDecimal128Precision)
+		}
 		chType = fmt.Sprintf("%s(%d, %d)", chType, pgColumn.Ext[0], pgColumn.Ext[1])
 	case utils.PgCharacter:
 		fallthrough
@@ -58,15 +102,163 @@ func ToClickHouseType(pgColumn config.PgColumn) (string, error) {
 			return "", fmt.Errorf("length must be specified for character type")
 		}
 		chType = fmt.Sprintf("%s(%d)", chType, pgColumn.Ext[0])
+	case utils.PgDate:
+		// the driver can't write Date32, so the dates before 1970 and after 2149 that pg_stats shows
+		// in the column go into DateTime64
+		if !timeFits(pgColumn, utils.ChDate) {
+			chType = dateTime64Type(0, pgColumn.TimeZone)
+		}
+	case utils.PgTimestamp:
+		fallthrough
+	case utils.PgTimestampWithoutTimeZone:
+		precision := defaultTimestampPrecision
+		if pgColumn.Ext != nil {
+			precision = pgColumn.Ext[0]
+		}
+		chType = dateTime64Type(precision, pgColumn.TimeZone)
+	case utils.PgTimestampWithTimeZone:
+		precision := defaultTimestampPrecision
+		if pgColumn.Ext != nil {
+			precision = pgColumn.Ext[0]
+		}
+		chType = fmt.Sprintf("%s(%d)", chType, precision)
+	case utils.PgTime:
+		fallthrough
+	case utils.PgTimeWithoutTimeZone:
+		fallthrough
+	case utils.PgTimeWithTimeZone:
+		if colCfg.TimeUnit == config.TimeUnitMicroseconds {
+			chType = utils.ChInt64
+		}
 	}
 
 	if pgColumn.IsArray {
 		chType = fmt.Sprintf("Array(%s)", chType)
 	}
 
-	if pgColumn.IsNullable && !pgColumn.IsArray {
+	// out of range dates and timestamps are stored as nulls; the columns pg_stats shows such values in
+	// are suggested to be Nullable, unless the values are clamped
+	isNullable := pgColumn.IsNullable
+	if baseType, ok := timeBaseType(chType); ok {
+		isNullable = isNullable || colCfg.OutOfRange == config.OutOfRangeNull ||
+			colCfg.OutOfRange != config.OutOfRangeClamp && (pgColumn.HasInfinity || !timeFits(pgColumn, baseType))
+	}
+
+	if isNullable && !pgColumn.IsArray {
 		chType = fmt.Sprintf("Nullable(%s)", chType)
 	}
 
 	return chType, nil
 }
+
+// MeasureType returns the clickhouse type of the column holding the sums of the numeric column values:
+// integers are summed into Int64, floats into Float64, numerics into Decimal of at least 18 digits precision
+func MeasureType(pgColumn config.PgColumn) (string, error) {
+	if pgColumn.IsArray {
+		return "", fmt.Errorf("array can't be a measure")
+	}
+
+	switch pgColumn.BaseType {
+	case utils.PgSmallint:
+		fallthrough
+	case utils.PgInteger:
+		fallthrough
+	case utils.PgBigint:
+		return utils.ChInt64, nil
+	case utils.PgReal:
+		fallthrough
+	case utils.PgDoublePrecision:
+		return utils.ChFloat64, nil
+	case utils.PgDecimal:
+		fallthrough
+	case utils.PgNumeric:
+		if pgColumn.Ext == nil {
+			return "", fmt.Errorf("precision must be specified for the numeric type")
+		}
+
+		precision := pgColumn.Ext[0]
+		if precision > utils.MaxDecimal128Precision {
+			return "", fmt.Errorf("numeric precision %d is beyond %d, the max one of Decimal128", precision,
+				utils.MaxDecimal128Precision)
+		}
+		if precision < utils.MaxDecimal64Precision {
+			precision = utils.MaxDecimal64Precision
+		}
+
+		return fmt.Sprintf("%s(%d, %d)", utils.ChDecimal, precision, pgColumn.Ext[1]), nil
+	}
+
+	return "", fmt.Errorf("%s is not a numeric type", pgColumn.BaseType)
+}
+
+// CheckWritable checks if the driver can write values of the clickhouse type
+func CheckWritable(chType config.Column) error {
+	if _, ok := writableChTypes[chType.BaseType]; !ok {
+		return fmt.Errorf("%s type is not supported by the driver", chType.BaseType)
+	}
+
+	if chType.BaseType == utils.ChDecimal && len(chType.Ext) == 2 && chType.Ext[0] > utils.MaxDecimal128Precision {
+		return fmt.Errorf("decimals of precision beyond %d are not supported by the driver", utils.MaxDecimal128Precision)
+	}
+
+	return nil
+}
+
+// dateTime64Type returns the DateTime64 type; values are stored as unix timestamps,
+// so they are shown in the same timezone they were in the source db
+func dateTime64Type(precision int, loc *time.Location) string {
+	if loc != nil && loc != time.UTC {
+		return fmt.Sprintf("%s(%d, '%s')", utils.ChDateTime64, precision, loc.String())
+	}
+
+	return fmt.Sprintf("%s(%d)", utils.ChDateTime64, precision)
+}
+
+// timeFits checks if the values of the column according to the pg_stats fit into the range of the clickhouse type
+func timeFits(pgColumn config.PgColumn, chType string) bool {
+	bounds := utils.ChTimeRanges[chType]
+
+	if !pgColumn.MinTime.IsZero() && pgColumn.MinTime.Before(bounds[0]) {
+		return false
+	}
+
+	return pgColumn.MaxTime.IsZero() || !pgColumn.MaxTime.After(bounds[1])
+}
+
+// timeBaseType returns the base type of the clickhouse date or time type
+func timeBaseType(chType string) (string, bool) {
+	for baseType := range utils.ChTimeRanges {
+		if chType == baseType || strings.HasPrefix(chType, baseType+"(") {
+			return baseType, true
+		}
+	}
+
+	return "", false
+}
+
+// EnumType returns definition of the Enum8 or Enum16 type with the values ordered by their codes
+func EnumType(values map[string]int) string {
+	labels := make([]string, 0, len(values))
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool { return values[labels[i]] < values[labels[j]] })
+
+	enumType := utils.ChEnum8
+	items := make([]string, len(labels))
+	for i, label := range labels {
+		code := values[label]
+		if code < math.MinInt8 || code > math.MaxInt8 {
+			enumType = utils.ChEnum16
+		}
+
+		items[i] = fmt.Sprintf("%s = %d", QuoteString(label), code)
+	}
+
+	return fmt.Sprintf("%s(%s)", enumType, strings.Join(items, ", "))
+}
+
+// QuoteString quotes clickhouse string literal
+func QuoteString(str string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(str) + "'"
+}
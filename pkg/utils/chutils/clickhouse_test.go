@@ -0,0 +1,56 @@
+package chutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mkabilov/pg2ch/pkg/config"
+	"github.com/mkabilov/pg2ch/pkg/utils"
+)
+
+func TestToClickHouseTypeTimeStats(t *testing.T) {
+	date := func(year int) time.Time { return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		pgType      string
+		minTime     time.Time
+		maxTime     time.Time
+		hasInfinity bool
+		outOfRange  string
+		expected    string
+	}{
+		{pgType: utils.PgDate, expected: "Date"},
+		{pgType: utils.PgDate, minTime: date(2000), maxTime: date(2100), expected: "Date"},
+		{pgType: utils.PgDate, minTime: date(1950), maxTime: date(2000), expected: "DateTime64(0)"},
+		{pgType: utils.PgDate, minTime: date(2000), maxTime: date(2200), expected: "DateTime64(0)"},
+		{pgType: utils.PgDate, minTime: date(1800), maxTime: date(2000), expected: "Nullable(DateTime64(0))"},
+		{pgType: utils.PgDate, minTime: date(1800), maxTime: date(2000), outOfRange: config.OutOfRangeClamp,
+			expected: "DateTime64(0)"},
+		{pgType: utils.PgDate, minTime: date(2000), maxTime: date(2020), hasInfinity: true, expected: "Nullable(Date)"},
+		{pgType: utils.PgDate, minTime: date(2000), maxTime: date(2020), hasInfinity: true,
+			outOfRange: config.OutOfRangeClamp, expected: "Date"},
+		{pgType: utils.PgDate, outOfRange: config.OutOfRangeNull, expected: "Nullable(Date)"},
+		{pgType: utils.PgTimestamp, minTime: date(1950), maxTime: date(2000), expected: "DateTime64(6)"},
+		{pgType: utils.PgTimestamp, minTime: date(2000), maxTime: date(2300), expected: "Nullable(DateTime64(6))"},
+	}
+
+	for _, tt := range tests {
+		pgCol := config.PgColumn{
+			Column:      config.Column{BaseType: tt.pgType},
+			MinTime:     tt.minTime,
+			MaxTime:     tt.maxTime,
+			HasInfinity: tt.hasInfinity,
+		}
+
+		res, err := ToClickHouseType(pgCol, config.ColumnConfig{OutOfRange: tt.outOfRange})
+		if err != nil {
+			t.Errorf("ToClickHouseType(%s %v..%v): unexpected error: %v", tt.pgType, tt.minTime, tt.maxTime, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("ToClickHouseType(%s %v..%v, infinity: %t, out of range: %q) = %q, expected %q",
+				tt.pgType, tt.minTime, tt.maxTime, tt.hasInfinity, tt.outOfRange, res, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DecodeComposite extracts fields from the composite type (row) text representation, e.g. (1,"a b",,"")
+// missing fields stand for nulls, nested composites and arrays are returned as their text representation
+func DecodeComposite(str string) ([]sql.NullString, error) {
+	str = strings.TrimSpace(str)
+	if len(str) < 2 || str[0] != '(' || str[len(str)-1] != ')' {
+		return nil, fmt.Errorf("composite value must be enclosed in parentheses: %q", str)
+	}
+
+	if str == "()" {
+		return []sql.NullString{}, nil
+	}
+
+	p := &arrayParser{src: str[:len(str)-1], pos: 1}
+	result := make([]sql.NullString, 0)
+	for {
+		item, err := p.parseRecordItem()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %q composite value: %v", str, err)
+		}
+		result = append(result, item)
+
+		if p.eof() {
+			break
+		}
+
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("could not parse %q composite value: expected \",\" at position %d", str, p.pos)
+		}
+		p.pos++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeComposite(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected []sql.NullString
+		fails    bool
+	}{
+		{str: "()", expected: []sql.NullString{}},
+		{str: `(1,"a b",,"")`, expected: []sql.NullString{str("1"), str("a b"), null, str("")}},
+		{str: `(,)`, expected: []sql.NullString{null, null}},
+		{str: `("a""b","c\\d")`, expected: []sql.NullString{str(`a"b`), str(`c\d`)}},
+		{str: `("(1,2)","{1,2}")`, expected: []sql.NullString{str("(1,2)"), str("{1,2}")}},
+		{str: ` (x) `, expected: []sql.NullString{str("x")}},
+		{str: "", fails: true},
+		{str: "1,2", fails: true},
+		{str: "(1,2", fails: true},
+		{str: `("a)`, fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := DecodeComposite(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("DecodeComposite(%q): expected error, got %v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("DecodeComposite(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("DecodeComposite(%q) = %#v, expected %#v", tt.str, res, tt.expected)
+		}
+	}
+}
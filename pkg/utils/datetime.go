@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pgDateLayout      = "2006-01-02"
+	pgTimestampLayout = "2006-01-02 15:04:05"
+	pgInfinity        = "infinity"
+	pgBCSuffix        = " BC"
+
+	// MaxDateTime64Precision is the max precision of the DateTime64 clickhouse type
+	MaxDateTime64Precision = 9
+)
+
+var (
+	// PgInfinity and PgNegativeInfinity stand for the infinity and -infinity values,
+	// they are beyond the range of the finite postgresql dates and timestamps
+	PgInfinity         = time.Date(294277, time.January, 1, 0, 0, 0, 0, time.UTC)
+	PgNegativeInfinity = time.Date(-4714, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// ChTimeRanges contains the earliest and the latest values of the clickhouse date and time types
+	ChTimeRanges = map[string][2]time.Time{
+		ChDate: {
+			time.Unix(0, 0).UTC(),
+			time.Date(2149, time.June, 6, 0, 0, 0, 0, time.UTC),
+		},
+		ChDateTime: {
+			time.Unix(0, 0).UTC(),
+			time.Unix(1<<32-1, 0).UTC(),
+		},
+		ChDateTime64: { // the driver writes the values as nanoseconds since the unix epoch
+			time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC),
+			time.Unix(0, math.MaxInt64).UTC(),
+		},
+	}
+)
+
+// offset layouts by the length of the utc offset part, e.g. +03, +05:30 or +05:30:15
+var utcOffsetLayouts = map[int]string{
+	3: "-07",
+	6: "-07:00",
+	9: "-07:00:00",
+}
+
+// ParseTimestamp parses postgresql's date, timestamp or timestamptz text representation (ISO DateStyle);
+// values without utc offset are treated as the ones in the loc timezone; fractional seconds are preserved.
+// infinity and -infinity are returned as PgInfinity and PgNegativeInfinity, BC dates as the ones of the
+// non-positive years (astronomical year numbering)
+func ParseTimestamp(val string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	switch val {
+	case pgInfinity:
+		return PgInfinity, nil
+	case "-" + pgInfinity:
+		return PgNegativeInfinity, nil
+	}
+
+	if strings.HasSuffix(val, pgBCSuffix) {
+		t, err := ParseTimestamp(val[:len(val)-len(pgBCSuffix)], loc)
+		if err != nil {
+			return t, err
+		}
+
+		return setYear(t, 1-t.Year()), nil
+	}
+
+	// years after 9999 have more than 4 digits
+	if yearLen := strings.IndexByte(val, '-'); yearLen > 4 {
+		year, err := strconv.Atoi(val[:yearLen])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp: %q", val)
+		}
+
+		// leap year so that February 29 is accepted
+		t, err := ParseTimestamp("2000"+val[yearLen:], loc)
+		if err != nil {
+			return t, err
+		}
+
+		return setYear(t, year), nil
+	}
+
+	return parseTimestamp(val, loc)
+}
+
+func parseTimestamp(val string, loc *time.Location) (time.Time, error) {
+	if len(val) == len(pgDateLayout) {
+		return time.ParseInLocation(pgDateLayout, val, loc)
+	}
+
+	if len(val) < len(pgTimestampLayout) {
+		return time.Time{}, fmt.Errorf("invalid timestamp: %q", val)
+	}
+
+	// utc offset follows the time part, date part contains dashes too
+	offsetPos := strings.LastIndexAny(val[len(pgDateLayout):], "+-")
+	if offsetPos < 0 {
+		return time.ParseInLocation(pgTimestampLayout, val, loc)
+	}
+	offsetPos += len(pgDateLayout)
+
+	offsetLayout, ok := utcOffsetLayouts[len(val)-offsetPos]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid utc offset of the timestamp: %q", val)
+	}
+
+	return time.Parse(pgTimestampLayout+".999999999"+offsetLayout, val)
+}
+
+// TruncateTime truncates time to the given number of digits of the fractional seconds
+func TruncateTime(t time.Time, precision int) time.Time {
+	if precision >= MaxDateTime64Precision {
+		return t
+	}
+
+	d := time.Duration(1)
+	for i := precision; i < MaxDateTime64Precision; i++ {
+		d *= 10
+	}
+
+	return t.Truncate(d)
+}
+
+func setYear(t time.Time, year int) time.Time {
+	return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
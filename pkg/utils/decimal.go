@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxDecimal32Precision is the max precision of the Decimal32 clickhouse type
+	MaxDecimal32Precision = 9
+	// MaxDecimal64Precision is the max precision of the Decimal64 clickhouse type
+	MaxDecimal64Precision = 18
+	// MaxDecimal128Precision is the max precision of the Decimal128 clickhouse type
+	MaxDecimal128Precision = 38
+	// MaxDecimal256Precision is the max precision of the Decimal256 clickhouse type
+	MaxDecimal256Precision = 76
+)
+
+var bigTen = big.NewInt(10)
+
+// ParseDecimal parses text representation of the number into the integer scaled by 10^scale,
+// extra fractional digits are rounded half away from zero, as postgresql does
+func ParseDecimal(str string, precision, scale int) (*big.Int, error) {
+	var (
+		exp      int
+		err      error
+		negative bool
+	)
+
+	str = strings.TrimSpace(str)
+	switch strings.ToLower(str) {
+	case "nan", "infinity", "+infinity", "-infinity", "inf", "+inf", "-inf":
+		return nil, fmt.Errorf("%s value is not supported by the clickhouse decimal types", str)
+	}
+
+	if str != "" && (str[0] == '-' || str[0] == '+') {
+		negative = str[0] == '-'
+		str = str[1:]
+	}
+
+	if idx := strings.IndexAny(str, "eE"); idx >= 0 {
+		exp, err = strconv.Atoi(str[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %v", err)
+		}
+		str = str[:idx]
+	}
+
+	intPart, fracPart := str, ""
+	if idx := strings.IndexByte(str, '.'); idx >= 0 {
+		intPart, fracPart = str[:idx], str[idx+1:]
+	}
+
+	digits := intPart + fracPart
+	if digits == "" || !isDigits(digits) {
+		return nil, fmt.Errorf("invalid decimal value")
+	}
+
+	// number of digits after the decimal point we have to get rid of (negative means we have to add zeros)
+	extraDigits := len(fracPart) - exp - scale
+	roundUp := false
+	if extraDigits > 0 {
+		if extraDigits > len(digits) {
+			digits = "0"
+		} else {
+			roundUp = digits[len(digits)-extraDigits] >= '5'
+			digits = digits[:len(digits)-extraDigits]
+		}
+	} else if extraDigits < 0 {
+		digits += strings.Repeat("0", -extraDigits)
+	}
+
+	if digits == "" {
+		digits = "0"
+	}
+
+	res, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal value")
+	}
+
+	if roundUp {
+		res.Add(res, big.NewInt(1))
+	}
+
+	if res.Cmp(new(big.Int).Exp(bigTen, big.NewInt(int64(precision)), nil)) >= 0 {
+		return nil, fmt.Errorf("value is out of range for Decimal(%d, %d)", precision, scale)
+	}
+
+	if negative {
+		res.Neg(res)
+	}
+
+	return res, nil
+}
+
+func isDigits(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] < '0' || str[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
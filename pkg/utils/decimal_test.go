@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		str       string
+		precision int
+		scale     int
+		expected  string
+		fails     bool
+	}{
+		{str: "123.45", precision: 5, scale: 2, expected: "12345"},
+		{str: "-123.45", precision: 5, scale: 2, expected: "-12345"},
+		{str: "+1", precision: 5, scale: 2, expected: "100"},
+		{str: "0.125", precision: 5, scale: 2, expected: "13"},
+		{str: "0.124", precision: 5, scale: 2, expected: "12"},
+		{str: "-0.125", precision: 5, scale: 2, expected: "-13"},
+		{str: "0.005", precision: 5, scale: 2, expected: "1"},
+		{str: "0.0049", precision: 5, scale: 2, expected: "0"},
+		{str: "9.995", precision: 3, scale: 2, fails: true},
+		{str: "9.994", precision: 3, scale: 2, expected: "999"},
+		{str: "1.5e2", precision: 5, scale: 0, expected: "150"},
+		{str: "15E-1", precision: 5, scale: 0, expected: "2"},
+		{str: "1e-10", precision: 5, scale: 2, expected: "0"},
+		{str: ".5", precision: 3, scale: 1, expected: "5"},
+		{str: "12.", precision: 3, scale: 1, expected: "120"},
+		{str: "99999999999999999999999999999999999999", precision: 38, scale: 0,
+			expected: "99999999999999999999999999999999999999"},
+		{str: "100000", precision: 5, scale: 0, fails: true},
+		{str: "NaN", precision: 5, scale: 2, fails: true},
+		{str: "-Infinity", precision: 5, scale: 2, fails: true},
+		{str: "1.2.3", precision: 5, scale: 2, fails: true},
+		{str: "", precision: 5, scale: 2, fails: true},
+		{str: "1e", precision: 5, scale: 2, fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := ParseDecimal(tt.str, tt.precision, tt.scale)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseDecimal(%q, %d, %d): expected error, got %v", tt.str, tt.precision, tt.scale, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseDecimal(%q, %d, %d): unexpected error: %v", tt.str, tt.precision, tt.scale, err)
+			continue
+		}
+
+		if res.String() != tt.expected {
+			t.Errorf("ParseDecimal(%q, %d, %d) = %v, expected %s", tt.str, tt.precision, tt.scale, res, tt.expected)
+		}
+	}
+}
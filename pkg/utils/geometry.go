@@ -0,0 +1,500 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kinds of the supported geometries
+const (
+	GeometryPoint        = "Point"
+	GeometryPolygon      = "Polygon"
+	GeometryMultiPolygon = "MultiPolygon"
+)
+
+const (
+	wkbPoint        = 1
+	wkbPolygon      = 3
+	wkbMultiPolygon = 6
+
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+)
+
+var wkbKinds = map[uint32]string{
+	1: "Point",
+	2: "LineString",
+	3: "Polygon",
+	4: "MultiPoint",
+	5: "MultiLineString",
+	6: "MultiPolygon",
+	7: "GeometryCollection",
+}
+
+// Point represents x and y coordinates
+type Point [2]float64
+
+// Ring represents closed line of the polygon
+type Ring []Point
+
+// Polygon represents outer ring followed by the holes
+type Polygon []Ring
+
+// MultiPolygon represents list of polygons
+type MultiPolygon []Polygon
+
+// Geometry represents decoded point, polygon or multipolygon; z and m coordinates are dropped
+type Geometry struct {
+	Kind         string
+	SRID         int
+	Point        Point
+	MultiPolygon MultiPolygon // the only polygon in case of the Polygon kind
+}
+
+// ParseGeometry parses either point, box and polygon text representation, e.g. (1,2) or ((0,0),(1,0),(1,1)),
+// or PostGIS geometry in the hex encoded EWKB, e.g. 0101000020E6100000..., or EWKT, e.g. SRID=4326;POINT(1 2)
+func ParseGeometry(val string) (Geometry, error) {
+	val = strings.TrimSpace(val)
+
+	switch {
+	case val == "":
+		return Geometry{}, fmt.Errorf("empty geometry")
+	case val[0] == '(':
+		return parsePgGeometry(val)
+	case isHex(val):
+		return parseEWKB(val)
+	}
+
+	return parseEWKT(val)
+}
+
+// WKT returns well-known text of the geometry without the srid, e.g. POLYGON((0 0,1 0,1 1,0 0))
+func (g Geometry) WKT() string {
+	str := &strings.Builder{}
+
+	switch g.Kind {
+	case GeometryPoint:
+		str.WriteString("POINT")
+		writeWKTRing(str, Ring{g.Point})
+	case GeometryPolygon:
+		str.WriteString("POLYGON")
+		writeWKTPolygon(str, g.MultiPolygon[0])
+	case GeometryMultiPolygon:
+		str.WriteString("MULTIPOLYGON(")
+		for i, polygon := range g.MultiPolygon {
+			if i > 0 {
+				str.WriteByte(',')
+			}
+			writeWKTPolygon(str, polygon)
+		}
+		str.WriteByte(')')
+	}
+
+	return str.String()
+}
+
+func writeWKTPolygon(str *strings.Builder, polygon Polygon) {
+	str.WriteByte('(')
+	for i, ring := range polygon {
+		if i > 0 {
+			str.WriteByte(',')
+		}
+		writeWKTRing(str, ring)
+	}
+	str.WriteByte(')')
+}
+
+func writeWKTRing(str *strings.Builder, ring Ring) {
+	str.WriteByte('(')
+	for i, point := range ring {
+		if i > 0 {
+			str.WriteByte(',')
+		}
+		str.WriteString(strconv.FormatFloat(point[0], 'f', -1, 64))
+		str.WriteByte(' ')
+		str.WriteString(strconv.FormatFloat(point[1], 'f', -1, 64))
+	}
+	str.WriteByte(')')
+}
+
+// parsePgGeometry parses point (x,y), box (x1,y1),(x2,y2) and polygon ((x1,y1),...) values of the built-in types
+func parsePgGeometry(val string) (Geometry, error) {
+	coords := strings.FieldsFunc(val, func(r rune) bool { return r == '(' || r == ')' || r == ',' || r == ' ' })
+
+	if len(coords) == 0 || len(coords)%2 != 0 {
+		return Geometry{}, fmt.Errorf("malformed geometry: %q", val)
+	}
+
+	points := make(Ring, len(coords)/2)
+	for i := range points {
+		for j := 0; j < 2; j++ {
+			coord, err := strconv.ParseFloat(coords[i*2+j], 64)
+			if err != nil {
+				return Geometry{}, fmt.Errorf("malformed geometry %q: %v", val, err)
+			}
+			points[i][j] = coord
+		}
+	}
+
+	if len(points) == 1 && strings.Count(val, "(") == 1 {
+		return Geometry{Kind: GeometryPoint, Point: points[0]}, nil
+	}
+
+	if len(points) == 2 && !strings.HasPrefix(val, "((") { // box is stored as its upper right and lower left corners
+		minX, maxX := math.Min(points[0][0], points[1][0]), math.Max(points[0][0], points[1][0])
+		minY, maxY := math.Min(points[0][1], points[1][1]), math.Max(points[0][1], points[1][1])
+		points = Ring{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}}
+	}
+
+	// postgresql polygons are implicitly closed
+	if points[0] != points[len(points)-1] {
+		points = append(points, points[0])
+	}
+
+	return Geometry{Kind: GeometryPolygon, MultiPolygon: MultiPolygon{{points}}}, nil
+}
+
+// parseEWKT parses extended well-known text, e.g. SRID=4326;POLYGON((0 0,1 0,1 1,0 0))
+func parseEWKT(val string) (Geometry, error) {
+	var res Geometry
+
+	str := val
+	if strings.HasPrefix(strings.ToUpper(str), "SRID=") {
+		idx := strings.IndexByte(str, ';')
+		if idx < 0 {
+			return res, fmt.Errorf("malformed geometry: %q", val)
+		}
+
+		srid, err := strconv.Atoi(str[5:idx])
+		if err != nil {
+			return res, fmt.Errorf("malformed geometry srid %q: %v", val, err)
+		}
+		res.SRID, str = srid, str[idx+1:]
+	}
+
+	idx := strings.IndexByte(str, '(')
+	if idx < 0 {
+		return res, fmt.Errorf("unsupported geometry: %q", val)
+	}
+
+	kind := strings.ToUpper(strings.TrimSpace(str[:idx]))
+	for _, suffix := range []string{"ZM", "Z", "M"} {
+		kind = strings.TrimSpace(strings.TrimSuffix(kind, suffix))
+	}
+
+	depth := 0
+	switch kind {
+	case "POINT":
+		res.Kind, depth = GeometryPoint, 1
+	case "POLYGON":
+		res.Kind, depth = GeometryPolygon, 2
+	case "MULTIPOLYGON":
+		res.Kind, depth = GeometryMultiPolygon, 3
+	default:
+		return res, fmt.Errorf("unsupported geometry kind: %s", kind)
+	}
+
+	p := &wktParser{src: str, pos: idx}
+	items, err := p.parseList(depth)
+	if err != nil {
+		return res, fmt.Errorf("malformed geometry %q: %v", val, err)
+	}
+
+	if p.pos != len(strings.TrimRight(p.src, " ")) {
+		return res, fmt.Errorf("malformed geometry %q: junk at position %d", val, p.pos)
+	}
+
+	switch res.Kind {
+	case GeometryPoint:
+		ring := items.(Ring)
+		if len(ring) != 1 {
+			return res, fmt.Errorf("malformed geometry: %q", val)
+		}
+		res.Point = ring[0]
+	case GeometryPolygon:
+		res.MultiPolygon = MultiPolygon{items.(Polygon)}
+	case GeometryMultiPolygon:
+		res.MultiPolygon = items.(MultiPolygon)
+	}
+
+	return res, nil
+}
+
+type wktParser struct {
+	src string
+	pos int
+}
+
+// parseList parses parenthesized list, depth 1 stands for the list of points
+func (p *wktParser) parseList(depth int) (interface{}, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.src) || p.src[p.pos] != '(' {
+		return nil, fmt.Errorf("expected \"(\" at position %d", p.pos)
+	}
+	p.pos++
+
+	var (
+		ring         Ring
+		polygon      Polygon
+		multiPolygon MultiPolygon
+	)
+
+	for {
+		p.skipSpaces()
+		if depth == 1 {
+			point, err := p.parsePoint()
+			if err != nil {
+				return nil, err
+			}
+			ring = append(ring, point)
+		} else {
+			item, err := p.parseList(depth - 1)
+			if err != nil {
+				return nil, err
+			}
+
+			if depth == 2 {
+				polygon = append(polygon, item.(Ring))
+			} else {
+				multiPolygon = append(multiPolygon, item.(Polygon))
+			}
+		}
+
+		p.skipSpaces()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("unexpected end of input")
+		}
+
+		ch := p.src[p.pos]
+		p.pos++
+		if ch == ')' {
+			break
+		}
+
+		if ch != ',' {
+			return nil, fmt.Errorf("unexpected %q at position %d", ch, p.pos-1)
+		}
+	}
+
+	switch depth {
+	case 1:
+		return ring, nil
+	case 2:
+		return polygon, nil
+	}
+
+	return multiPolygon, nil
+}
+
+// parsePoint parses space separated coordinates, e.g. 1 2 or 1 2 3, the ones after x and y are dropped
+func (p *wktParser) parsePoint() (Point, error) {
+	var point Point
+
+	end := strings.IndexAny(p.src[p.pos:], ",)")
+	if end < 0 {
+		return point, fmt.Errorf("unexpected end of input")
+	}
+
+	coords := strings.Fields(p.src[p.pos : p.pos+end])
+	if len(coords) < 2 {
+		return point, fmt.Errorf("point must have at least two coordinates at position %d", p.pos)
+	}
+
+	for i := 0; i < 2; i++ {
+		coord, err := strconv.ParseFloat(coords[i], 64)
+		if err != nil {
+			return point, err
+		}
+		point[i] = coord
+	}
+	p.pos += end
+
+	return point, nil
+}
+
+func (p *wktParser) skipSpaces() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// parseEWKB parses hex encoded extended well-known binary, the text output of the PostGIS geometry type
+func parseEWKB(val string) (Geometry, error) {
+	data, err := hex.DecodeString(val)
+	if err != nil {
+		return Geometry{}, fmt.Errorf("malformed geometry: %v", err)
+	}
+
+	r := &wkbReader{data: data}
+	res, err := r.readGeometry()
+	if err != nil {
+		return Geometry{}, fmt.Errorf("could not decode geometry: %v", err)
+	}
+
+	if len(r.data) > 0 {
+		return Geometry{}, fmt.Errorf("malformed geometry: %d extra bytes", len(r.data))
+	}
+
+	return res, nil
+}
+
+type wkbReader struct {
+	data      []byte
+	byteOrder binary.ByteOrder
+	dims      int
+}
+
+func (r *wkbReader) readGeometry() (Geometry, error) {
+	var res Geometry
+
+	if len(r.data) < 5 {
+		return res, fmt.Errorf("unexpected end of input")
+	}
+
+	if r.data[0] == 1 {
+		r.byteOrder = binary.LittleEndian
+	} else {
+		r.byteOrder = binary.BigEndian
+	}
+	r.data = r.data[1:]
+
+	typ, err := r.readUint32()
+	if err != nil {
+		return res, err
+	}
+
+	r.dims = 2
+	if typ&ewkbZ != 0 {
+		r.dims++
+	}
+	if typ&ewkbM != 0 {
+		r.dims++
+	}
+
+	if typ&ewkbSRID != 0 {
+		srid, err := r.readUint32()
+		if err != nil {
+			return res, err
+		}
+		res.SRID = int(srid)
+	}
+
+	typ &= 0x0fffffff
+	if typ >= 1000 { // iso wkb: 1000s for z, 2000s for m and 3000s for zm
+		if typ/1000 == 3 {
+			r.dims += 2
+		} else {
+			r.dims++
+		}
+		typ %= 1000
+	}
+
+	switch typ {
+	case wkbPoint:
+		res.Kind = GeometryPoint
+		res.Point, err = r.readPoint()
+	case wkbPolygon:
+		var polygon Polygon
+		res.Kind = GeometryPolygon
+		polygon, err = r.readPolygon()
+		res.MultiPolygon = MultiPolygon{polygon}
+	case wkbMultiPolygon:
+		var n uint32
+		res.Kind = GeometryMultiPolygon
+		if n, err = r.readUint32(); err != nil {
+			return res, err
+		}
+
+		for i := uint32(0); i < n; i++ {
+			polygon, err := r.readGeometry()
+			if err != nil {
+				return res, err
+			}
+
+			if polygon.Kind != GeometryPolygon {
+				return res, fmt.Errorf("multipolygon contains %s", polygon.Kind)
+			}
+			res.MultiPolygon = append(res.MultiPolygon, polygon.MultiPolygon[0])
+		}
+	default:
+		kind, ok := wkbKinds[typ]
+		if !ok {
+			kind = fmt.Sprintf("%d", typ)
+		}
+
+		return res, fmt.Errorf("unsupported geometry kind: %s", kind)
+	}
+
+	return res, err
+}
+
+func (r *wkbReader) readPolygon() (Polygon, error) {
+	rings, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	polygon := make(Polygon, 0, rings)
+	for i := uint32(0); i < rings; i++ {
+		points, err := r.readUint32()
+		if err != nil {
+			return nil, err
+		}
+
+		ring := make(Ring, 0, points)
+		for j := uint32(0); j < points; j++ {
+			point, err := r.readPoint()
+			if err != nil {
+				return nil, err
+			}
+			ring = append(ring, point)
+		}
+		polygon = append(polygon, ring)
+	}
+
+	return polygon, nil
+}
+
+func (r *wkbReader) readPoint() (Point, error) {
+	var point Point
+
+	if len(r.data) < 8*r.dims {
+		return point, fmt.Errorf("unexpected end of input")
+	}
+
+	point[0] = math.Float64frombits(r.byteOrder.Uint64(r.data))
+	point[1] = math.Float64frombits(r.byteOrder.Uint64(r.data[8:]))
+	r.data = r.data[8*r.dims:]
+
+	return point, nil
+}
+
+func (r *wkbReader) readUint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, fmt.Errorf("unexpected end of input")
+	}
+
+	val := r.byteOrder.Uint32(r.data)
+	r.data = r.data[4:]
+
+	return val, nil
+}
+
+func isHex(str string) bool {
+	if len(str)%2 != 0 {
+		return false
+	}
+
+	for _, ch := range str {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F') {
+			return false
+		}
+	}
+
+	return true
+}
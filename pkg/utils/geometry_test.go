@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGeometry(t *testing.T) {
+	square := Ring{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+
+	tests := []struct {
+		str      string
+		expected Geometry
+		wkt      string
+		fails    bool
+	}{
+		// built-in types
+		{str: "(1.5,-2)", expected: Geometry{Kind: GeometryPoint, Point: Point{1.5, -2}}, wkt: "POINT(1.5 -2)"},
+		{str: "(1,1),(0,0)", expected: Geometry{Kind: GeometryPolygon, MultiPolygon: MultiPolygon{{square}}},
+			wkt: "POLYGON((0 0,1 0,1 1,0 1,0 0))"},
+		{str: "((0,0),(1,0),(1,1),(0,1))", expected: Geometry{Kind: GeometryPolygon, MultiPolygon: MultiPolygon{{square}}},
+			wkt: "POLYGON((0 0,1 0,1 1,0 1,0 0))"},
+		// ewkt
+		{str: "SRID=4326;POINT(1 2)", expected: Geometry{Kind: GeometryPoint, SRID: 4326, Point: Point{1, 2}},
+			wkt: "POINT(1 2)"},
+		{str: "POINT Z (1 2 3)", expected: Geometry{Kind: GeometryPoint, Point: Point{1, 2}}, wkt: "POINT(1 2)"},
+		{str: "POLYGON((0 0,1 0,1 1,0 1,0 0),(0.2 0.2,0.4 0.2,0.2 0.4,0.2 0.2))",
+			expected: Geometry{Kind: GeometryPolygon, MultiPolygon: MultiPolygon{{square,
+				{{0.2, 0.2}, {0.4, 0.2}, {0.2, 0.4}, {0.2, 0.2}}}}},
+			wkt: "POLYGON((0 0,1 0,1 1,0 1,0 0),(0.2 0.2,0.4 0.2,0.2 0.4,0.2 0.2))"},
+		{str: "MULTIPOLYGON(((0 0,1 0,1 1,0 1,0 0)),((5 5,6 5,6 6,5 5)))",
+			expected: Geometry{Kind: GeometryMultiPolygon, MultiPolygon: MultiPolygon{{square},
+				{{{5, 5}, {6, 5}, {6, 6}, {5, 5}}}}},
+			wkt: "MULTIPOLYGON(((0 0,1 0,1 1,0 1,0 0)),((5 5,6 5,6 6,5 5)))"},
+		// ewkb: little and big endian, srid
+		{str: "0101000020E6100000000000000000F03F0000000000000040",
+			expected: Geometry{Kind: GeometryPoint, SRID: 4326, Point: Point{1, 2}}, wkt: "POINT(1 2)"},
+		{str: "00000000013FF00000000000004000000000000000",
+			expected: Geometry{Kind: GeometryPoint, Point: Point{1, 2}}, wkt: "POINT(1 2)"},
+		{str: "01010000800000000000000000000000000000F03F0000000000000840",
+			expected: Geometry{Kind: GeometryPoint, Point: Point{0, 1}}, wkt: "POINT(0 1)"},
+		{str: "", fails: true},
+		{str: "(1)", fails: true},
+		{str: "(1,x)", fails: true},
+		{str: "LINESTRING(0 0,1 1)", fails: true},
+		{str: "POINT(1)", fails: true},
+		{str: "POINT(1 2) x", fails: true},
+		{str: "POLYGON((0 0,1 0", fails: true},
+		{str: "SRID=x;POINT(1 2)", fails: true},
+		{str: "010200000000000000", fails: true},
+		{str: "0101000000000000000000F03F", fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := ParseGeometry(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseGeometry(%q): expected error, got %+v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseGeometry(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("ParseGeometry(%q) = %+v, expected %+v", tt.str, res, tt.expected)
+		}
+
+		if wkt := res.WKT(); wkt != tt.wkt {
+			t.Errorf("ParseGeometry(%q).WKT() = %q, expected %q", tt.str, wkt, tt.wkt)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const hstoreNull = "NULL"
+
+// DecodeHstore extracts key-value pairs from the hstore text representation, e.g. "a"=>"1", "b"=>NULL
+func DecodeHstore(str string) (map[string]sql.NullString, error) {
+	p := &arrayParser{src: str}
+	result := make(map[string]sql.NullString)
+
+	p.skipSpaces()
+	for !p.eof() {
+		key, err := p.parseHstoreItem()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %q hstore: %v", str, err)
+		}
+		if !key.Valid {
+			return nil, fmt.Errorf("could not parse %q hstore: null key at position %d", str, p.pos)
+		}
+
+		p.skipSpaces()
+		if !strings.HasPrefix(p.src[p.pos:], "=>") {
+			return nil, fmt.Errorf("could not parse %q hstore: expected \"=>\" at position %d", str, p.pos)
+		}
+		p.pos += 2
+		p.skipSpaces()
+
+		val, err := p.parseHstoreItem()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %q hstore: %v", str, err)
+		}
+		result[key.String] = val
+
+		p.skipSpaces()
+		if p.eof() {
+			break
+		}
+
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("could not parse %q hstore: unexpected character at position %d", str, p.pos)
+		}
+		p.pos++
+		p.skipSpaces()
+	}
+
+	return result, nil
+}
+
+// parseHstoreItem parses either quoted or unquoted key or value; unquoted NULL stands for null
+func (p *arrayParser) parseHstoreItem() (sql.NullString, error) {
+	if p.peek() == '"' {
+		return p.parseQuoted()
+	}
+
+	str := &strings.Builder{}
+	escaped := false
+	for !p.eof() {
+		ch := p.src[p.pos]
+		if ch == ',' || ch == '=' || ch == '"' || isArraySpace(ch) {
+			break
+		}
+		p.pos++
+
+		if ch == '\\' && !p.eof() {
+			ch = p.src[p.pos]
+			p.pos++
+			escaped = true
+		}
+		str.WriteByte(ch)
+	}
+
+	if str.Len() == 0 {
+		return sql.NullString{}, fmt.Errorf("empty item at position %d", p.pos)
+	}
+
+	if !escaped && strings.EqualFold(str.String(), hstoreNull) {
+		return sql.NullString{}, nil
+	}
+
+	return sql.NullString{Valid: true, String: str.String()}, nil
+}
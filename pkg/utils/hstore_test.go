@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeHstore(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected map[string]sql.NullString
+		fails    bool
+	}{
+		{str: "", expected: map[string]sql.NullString{}},
+		{str: `"a"=>"1", "b"=>NULL`, expected: map[string]sql.NullString{"a": str("1"), "b": null}},
+		{str: `"a"=>"NULL"`, expected: map[string]sql.NullString{"a": str("NULL")}},
+		{str: `a=>1,b=>null`, expected: map[string]sql.NullString{"a": str("1"), "b": null}},
+		{str: `"a b"=>"c,d", "e\"f"=>"g\\h"`, expected: map[string]sql.NullString{"a b": str("c,d"), `e"f`: str(`g\h`)}},
+		{str: ` "k" => "v" `, expected: map[string]sql.NullString{"k": str("v")}},
+		{str: `"k"=>""`, expected: map[string]sql.NullString{"k": str("")}},
+		{str: `a\=b=>\NULL`, expected: map[string]sql.NullString{"a=b": str("NULL")}},
+		{str: `NULL=>"1"`, fails: true},
+		{str: `"a"`, fails: true},
+		{str: `"a"=>`, fails: true},
+		{str: `"a"=>"1" "b"=>"2"`, fails: true},
+		{str: `"a"=>"1`, fails: true},
+		{str: `"a"->"1"`, fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := DecodeHstore(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("DecodeHstore(%q): expected error, got %v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("DecodeHstore(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("DecodeHstore(%q) = %#v, expected %#v", tt.str, res, tt.expected)
+		}
+	}
+}
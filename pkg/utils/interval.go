@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	microsPerSecond = int64(1000000)
+	microsPerMinute = 60 * microsPerSecond
+	microsPerHour   = 60 * microsPerMinute
+	microsPerDay    = 24 * microsPerHour
+
+	// the same factors are used by postgresql for extract(epoch from interval)
+	daysPerMonth   = 30
+	secondsPerYear = 31557600 // 365.25 days
+)
+
+// Interval represents postgresql's interval value
+type Interval struct {
+	Months       int32
+	Days         int32
+	Microseconds int64
+}
+
+// ParseInterval parses interval text representation in either postgres, postgres_verbose, sql_standard
+// or iso_8601 IntervalStyle
+func ParseInterval(val string) (Interval, error) {
+	val = strings.TrimSpace(val)
+
+	if strings.HasPrefix(val, "P") {
+		return parseISOInterval(val)
+	}
+
+	return parsePostgresInterval(val)
+}
+
+// TotalMicroseconds returns the total length of the interval, years are of 365.25 days and months are of 30 days
+func (i Interval) TotalMicroseconds() int64 {
+	years, months := int64(i.Months/12), int64(i.Months%12)
+
+	return years*secondsPerYear*microsPerSecond +
+		months*daysPerMonth*microsPerDay +
+		int64(i.Days)*microsPerDay +
+		i.Microseconds
+}
+
+// parsePostgresInterval parses postgres (1 year 2 mons -3 days +04:05:06.7),
+// postgres_verbose (@ 1 year 2 mons -3 days 4 hours 5 mins 6.7 secs ago)
+// and sql_standard (+1-2 -3 +4:05:06.7) interval styles
+func parsePostgresInterval(val string) (Interval, error) {
+	var res Interval
+
+	tokens := strings.Fields(strings.TrimPrefix(val, "@"))
+	if len(tokens) == 0 {
+		return res, fmt.Errorf("invalid interval: %q", val)
+	}
+
+	ago := false
+	if tokens[len(tokens)-1] == "ago" {
+		ago = true
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if strings.Contains(token, ":") {
+			micros, err := parseIntervalTime(token)
+			if err != nil {
+				return res, fmt.Errorf("invalid interval %q: %v", val, err)
+			}
+			res.Microseconds += micros
+			continue
+		}
+
+		if idx := strings.LastIndexByte(token, '-'); idx > 0 { // year-month of the sql_standard style
+			years, err := strconv.ParseInt(token[:idx], 10, 32)
+			if err != nil {
+				return res, fmt.Errorf("invalid interval %q: %v", val, err)
+			}
+			months, err := strconv.ParseInt(token[idx+1:], 10, 32)
+			if err != nil {
+				return res, fmt.Errorf("invalid interval %q: %v", val, err)
+			}
+
+			if years < 0 {
+				months = -months
+			}
+			res.Months += int32(years*12 + months)
+			continue
+		}
+
+		unit := "day" // bare number means days in the sql_standard style
+		if i+1 < len(tokens) && !strings.Contains(tokens[i+1], ":") && !startsWithDigitOrSign(tokens[i+1]) {
+			unit = strings.TrimSuffix(tokens[i+1], "s")
+			i++
+		}
+
+		if err := res.add(token, unit); err != nil {
+			return res, fmt.Errorf("invalid interval %q: %v", val, err)
+		}
+	}
+
+	if ago {
+		res = Interval{Months: -res.Months, Days: -res.Days, Microseconds: -res.Microseconds}
+	}
+
+	return res, nil
+}
+
+// parseISOInterval parses iso_8601 interval style, e.g. P1Y2M-3DT4H5M6.7S
+func parseISOInterval(val string) (Interval, error) {
+	var res Interval
+
+	isTime := false
+	str := val[1:]
+	for len(str) > 0 {
+		if str[0] == 'T' {
+			isTime = true
+			str = str[1:]
+			continue
+		}
+
+		idx := strings.IndexFunc(str, func(r rune) bool { return r >= 'A' && r <= 'Z' })
+		if idx <= 0 {
+			return res, fmt.Errorf("invalid interval: %q", val)
+		}
+
+		number, designator := str[:idx], str[idx]
+		str = str[idx+1:]
+
+		var unit string
+		switch {
+		case designator == 'Y' && !isTime:
+			unit = "year"
+		case designator == 'M' && !isTime:
+			unit = "mon"
+		case designator == 'W' && !isTime:
+			unit = "week"
+		case designator == 'D' && !isTime:
+			unit = "day"
+		case designator == 'H' && isTime:
+			unit = "hour"
+		case designator == 'M' && isTime:
+			unit = "min"
+		case designator == 'S' && isTime:
+			unit = "sec"
+		default:
+			return res, fmt.Errorf("invalid interval: %q", val)
+		}
+
+		if err := res.add(number, unit); err != nil {
+			return res, fmt.Errorf("invalid interval %q: %v", val, err)
+		}
+	}
+
+	return res, nil
+}
+
+func (i *Interval) add(number, unit string) error {
+	number = strings.TrimPrefix(number, "+")
+
+	if unit == "sec" || unit == "second" {
+		micros, err := ParseDecimal(number, MaxDecimal64Precision, 6)
+		if err != nil {
+			return err
+		}
+		i.Microseconds += micros.Int64()
+
+		return nil
+	}
+
+	n, err := strconv.ParseInt(number, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	switch unit {
+	case "year":
+		i.Months += int32(n * 12)
+	case "mon", "month":
+		i.Months += int32(n)
+	case "week":
+		i.Days += int32(n * 7)
+	case "day":
+		i.Days += int32(n)
+	case "hour":
+		i.Microseconds += n * microsPerHour
+	case "min", "minute":
+		i.Microseconds += n * microsPerMinute
+	default:
+		return fmt.Errorf("unknown unit: %q", unit)
+	}
+
+	return nil
+}
+
+// parseIntervalTime parses time part of the interval, e.g. -04:05:06.789; hours may exceed 24
+func parseIntervalTime(val string) (int64, error) {
+	negative := strings.HasPrefix(val, "-")
+	parts := strings.Split(strings.TrimLeft(val, "+-"), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time: %q", val)
+	}
+
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	res := hours*microsPerHour + minutes*microsPerMinute
+	if len(parts) == 3 {
+		micros, err := ParseDecimal(parts[2], MaxDecimal64Precision, 6)
+		if err != nil {
+			return 0, err
+		}
+		res += micros.Int64()
+	}
+
+	if negative {
+		res = -res
+	}
+
+	return res, nil
+}
+
+func startsWithDigitOrSign(str string) bool {
+	return str != "" && (str[0] == '+' || str[0] == '-' || (str[0] >= '0' && str[0] <= '9'))
+}
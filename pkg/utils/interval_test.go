@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected Interval
+		fails    bool
+	}{
+		// postgres
+		{str: "1 year 2 mons -3 days +04:05:06.7", expected: Interval{14, -3, 14706700000}},
+		{str: "00:00:00", expected: Interval{}},
+		{str: "-00:00:01.5", expected: Interval{0, 0, -1500000}},
+		{str: "100:00:00", expected: Interval{0, 0, 360000000000}},
+		{str: "1 day", expected: Interval{0, 1, 0}},
+		// postgres_verbose
+		{str: "@ 1 year 2 mons -3 days 4 hours 5 mins 6.7 secs ago", expected: Interval{-14, 3, -14706700000}},
+		{str: "@ 1 sec", expected: Interval{0, 0, 1000000}},
+		// sql_standard
+		{str: "+1-2 -3 +4:05:06.7", expected: Interval{14, -3, 14706700000}},
+		{str: "-1-2", expected: Interval{-14, 0, 0}},
+		{str: "3 4:05:06", expected: Interval{0, 3, 14706000000}},
+		// iso_8601
+		{str: "P1Y2M-3DT4H5M6.7S", expected: Interval{14, -3, 14706700000}},
+		{str: "P2W", expected: Interval{0, 14, 0}},
+		{str: "PT-1.5S", expected: Interval{0, 0, -1500000}},
+		{str: "", fails: true},
+		{str: "1 fortnight", fails: true},
+		{str: "1:2:3:4", fails: true},
+		{str: "P1H", fails: true},
+		{str: "PT1Y", fails: true},
+		{str: "P1", fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := ParseInterval(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseInterval(%q): expected error, got %+v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseInterval(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("ParseInterval(%q) = %+v, expected %+v", tt.str, res, tt.expected)
+		}
+	}
+}
+
+func TestIntervalTotalMicroseconds(t *testing.T) {
+	tests := []struct {
+		interval Interval
+		expected int64
+	}{
+		{Interval{12, 0, 0}, 31557600000000},
+		{Interval{1, 0, 0}, 2592000000000},
+		{Interval{13, 1, 1}, 31557600000000 + 2592000000000 + 86400000000 + 1},
+		{Interval{-1, -1, -1}, -2592000000000 - 86400000000 - 1},
+	}
+
+	for _, tt := range tests {
+		if res := tt.interval.TotalMicroseconds(); res != tt.expected {
+			t.Errorf("%+v.TotalMicroseconds() = %d, expected %d", tt.interval, res, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// DecodeJSON decodes json document keeping numbers as json.Number, so that big integers and decimals are not rounded
+func DecodeJSON(doc string) (interface{}, error) {
+	var res interface{}
+
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// JSONPath returns the value located by the object keys or array indexes inside the decoded json document,
+// nil if there is no such value
+func JSONPath(doc interface{}, keys []string) interface{} {
+	for _, key := range keys {
+		switch val := doc.(type) {
+		case map[string]interface{}:
+			doc = val[key]
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(val) {
+				return nil
+			}
+			doc = val[idx]
+		default:
+			return nil
+		}
+	}
+
+	return doc
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParseInet parses postgresql's inet or cidr text representation, e.g. 10.0.0.1, 10.0.0.0/8 or 2001:db8::/32,
+// returns the address and the network prefix length
+func ParseInet(val string) (net.IP, int, error) {
+	addr, prefixStr, hasPrefix := val, "", false
+	if idx := strings.IndexByte(val, '/'); idx >= 0 {
+		addr, prefixStr, hasPrefix = val[:idx], val[idx+1:], true
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid network address: %q", val)
+	}
+
+	maxPrefix := net.IPv6len * 8
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(addr, ":") {
+		ip = ip4
+		maxPrefix = net.IPv4len * 8
+	}
+
+	if !hasPrefix {
+		return ip, maxPrefix, nil
+	}
+
+	prefix, err := strconv.Atoi(prefixStr)
+	if err != nil || prefix < 0 || prefix > maxPrefix {
+		return nil, 0, fmt.Errorf("invalid network prefix length: %q", val)
+	}
+
+	return ip, prefix, nil
+}
+
+// ParseMacAddr parses postgresql's macaddr or macaddr8 text representation into the integer
+func ParseMacAddr(val string) (uint64, error) {
+	hw, err := net.ParseMAC(val)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 8)
+	copy(buf[8-len(hw):], hw)
+
+	return binary.BigEndian.Uint64(buf), nil
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseInet(t *testing.T) {
+	tests := []struct {
+		str    string
+		ip     net.IP
+		prefix int
+		fails  bool
+	}{
+		{str: "10.0.0.1", ip: net.IPv4(10, 0, 0, 1).To4(), prefix: 32},
+		{str: "10.0.0.0/8", ip: net.IPv4(10, 0, 0, 0).To4(), prefix: 8},
+		{str: "2001:db8::1", ip: net.ParseIP("2001:db8::1"), prefix: 128},
+		{str: "2001:db8::/32", ip: net.ParseIP("2001:db8::"), prefix: 32},
+		{str: "::ffff:10.0.0.1", ip: net.ParseIP("::ffff:10.0.0.1"), prefix: 128},
+		{str: "::ffff:10.0.0.1/120", ip: net.ParseIP("::ffff:10.0.0.1"), prefix: 120},
+		{str: "10.0.0.1/33", fails: true},
+		{str: "10.0.0.1/-1", fails: true},
+		{str: "10.0.0.1/", fails: true},
+		{str: "2001:db8::/129", fails: true},
+		{str: "10.0.0", fails: true},
+		{str: "", fails: true},
+	}
+
+	for _, tt := range tests {
+		ip, prefix, err := ParseInet(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseInet(%q): expected error, got %v/%d", tt.str, ip, prefix)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseInet(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if !ip.Equal(tt.ip) || len(ip) != len(tt.ip) || prefix != tt.prefix {
+			t.Errorf("ParseInet(%q) = %v/%d, expected %v/%d", tt.str, ip, prefix, tt.ip, tt.prefix)
+		}
+	}
+}
+
+func TestParseMacAddr(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected uint64
+		fails    bool
+	}{
+		{str: "08:00:2b:01:02:03", expected: 0x08002b010203},
+		{str: "08-00-2B-01-02-03", expected: 0x08002b010203},
+		{str: "0800.2b01.0203", expected: 0x08002b010203},
+		{str: "08:00:2b:01:02:03:04:05", expected: 0x08002b0102030405},
+		{str: "00:00:00:00:00:00", expected: 0},
+		{str: "08:00:2b:01:02", fails: true},
+		{str: "08:00:2b:01:02:zz", fails: true},
+		{str: "", fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := ParseMacAddr(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseMacAddr(%q): expected error, got %x", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseMacAddr(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("ParseMacAddr(%q) = %x, expected %x", tt.str, res, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	arrayDelimiter = ','
+	arrayNull      = "NULL"
+)
+
+type arrayParser struct {
+	src string
+	pos int
+}
+
+// DecodeArray extracts elements from the postgresql array text representation, e.g. {1,NULL,"a b"} or {{1,2},{3,4}}
+// each element of the result is either sql.NullString or []interface{} in case of multidimensional arrays
+func DecodeArray(str string) ([]interface{}, error) {
+	p := &arrayParser{src: str}
+
+	p.skipSpaces()
+	if p.peek() == '[' { // skip dimension decoration, e.g. [0:1]={1,2}
+		idx := strings.IndexByte(str, '=')
+		if idx < 0 {
+			return nil, fmt.Errorf("malformed array dimensions: %q", str)
+		}
+		p.pos = idx + 1
+		p.skipSpaces()
+	}
+
+	if p.peek() != '{' {
+		return nil, fmt.Errorf("array value must start with \"{\": %q", str)
+	}
+
+	result, err := p.parseArray()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q array: %v", str, err)
+	}
+
+	p.skipSpaces()
+	if !p.eof() {
+		return nil, fmt.Errorf("junk after closing right brace: %q", str)
+	}
+
+	return result, nil
+}
+
+func (p *arrayParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *arrayParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func (p *arrayParser) skipSpaces() {
+	for !p.eof() && isArraySpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *arrayParser) parseArray() ([]interface{}, error) {
+	result := make([]interface{}, 0)
+
+	p.pos++ // opening brace
+	p.skipSpaces()
+	if p.peek() == '}' {
+		p.pos++
+		return result, nil
+	}
+
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			return nil, fmt.Errorf("unexpected end of input")
+		}
+
+		switch p.peek() {
+		case '{':
+			items, err := p.parseArray()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, items)
+		case '"':
+			item, err := p.parseQuoted()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, item)
+		default:
+			item, err := p.parseUnquoted()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, item)
+		}
+
+		p.skipSpaces()
+		switch p.peek() {
+		case arrayDelimiter:
+			p.pos++
+		case '}':
+			p.pos++
+			return result, nil
+		default:
+			return nil, fmt.Errorf("unexpected character at position %d", p.pos)
+		}
+	}
+}
+
+func (p *arrayParser) parseQuoted() (sql.NullString, error) {
+	str := &strings.Builder{}
+
+	p.pos++ // opening quote
+	for !p.eof() {
+		ch := p.src[p.pos]
+		p.pos++
+
+		switch ch {
+		case '"':
+			return sql.NullString{Valid: true, String: str.String()}, nil
+		case '\\':
+			if p.eof() {
+				return sql.NullString{}, fmt.Errorf("unexpected end of input")
+			}
+			str.WriteByte(p.src[p.pos])
+			p.pos++
+		default:
+			str.WriteByte(ch)
+		}
+	}
+
+	return sql.NullString{}, fmt.Errorf("unterminated quoted element")
+}
+
+func (p *arrayParser) parseUnquoted() (sql.NullString, error) {
+	str := &strings.Builder{}
+	escaped := false
+	trailingSpaces := 0 // unescaped whitespaces at the end of the element are not part of it
+
+	for !p.eof() {
+		ch := p.src[p.pos]
+		if ch == arrayDelimiter || ch == '}' {
+			break
+		}
+		if ch == '{' || ch == '"' {
+			return sql.NullString{}, fmt.Errorf("unexpected %q at position %d", ch, p.pos)
+		}
+		p.pos++
+
+		if ch == '\\' {
+			if p.eof() {
+				return sql.NullString{}, fmt.Errorf("unexpected end of input")
+			}
+			str.WriteByte(p.src[p.pos])
+			p.pos++
+			escaped = true
+			trailingSpaces = 0
+			continue
+		}
+
+		if isArraySpace(ch) {
+			trailingSpaces++
+		} else {
+			trailingSpaces = 0
+		}
+		str.WriteByte(ch)
+	}
+
+	val := str.String()
+	val = val[:len(val)-trailingSpaces]
+	if val == "" && !escaped {
+		return sql.NullString{}, fmt.Errorf("empty array element at position %d", p.pos)
+	}
+
+	if !escaped && strings.EqualFold(val, arrayNull) {
+		return sql.NullString{Valid: false}, nil
+	}
+
+	return sql.NullString{Valid: true, String: val}, nil
+}
+
+func isArraySpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\v' || ch == '\f'
+}
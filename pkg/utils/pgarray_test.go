@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func str(val string) sql.NullString {
+	return sql.NullString{String: val, Valid: true}
+}
+
+var null = sql.NullString{}
+
+func TestDecodeArray(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected []interface{}
+		fails    bool
+	}{
+		{str: "{}", expected: []interface{}{}},
+		{str: "{1,2,3}", expected: []interface{}{str("1"), str("2"), str("3")}},
+		{str: "{1,NULL,null}", expected: []interface{}{str("1"), null, null}},
+		{str: `{"NULL",\NULL}`, expected: []interface{}{str("NULL"), str("NULL")}},
+		{str: `{"a b","c,d","e\"f","g\\h"}`, expected: []interface{}{str("a b"), str("c,d"), str(`e"f`), str(`g\h`)}},
+		{str: `{ a b , c }`, expected: []interface{}{str("a b"), str("c")}},
+		{str: `{a\ ,""}`, expected: []interface{}{str("a "), str("")}},
+		{str: "{{1,2},{3,NULL}}", expected: []interface{}{
+			[]interface{}{str("1"), str("2")},
+			[]interface{}{str("3"), null},
+		}},
+		{str: "{{}}", expected: []interface{}{[]interface{}{}}},
+		{str: "[0:1]={1,2}", expected: []interface{}{str("1"), str("2")}},
+		{str: "[0:1", fails: true},
+		{str: "1,2", fails: true},
+		{str: "{1,2", fails: true},
+		{str: "{1,,2}", fails: true},
+		{str: `{"a}`, fails: true},
+		{str: `{a"b}`, fails: true},
+		{str: "{1} x", fails: true},
+		{str: "{1 2 {3}}", fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := DecodeArray(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("DecodeArray(%q): expected error, got %v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("DecodeArray(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("DecodeArray(%q) = %#v, expected %#v", tt.str, res, tt.expected)
+		}
+	}
+}
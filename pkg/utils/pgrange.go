@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const rangeEmpty = "empty"
+
+// RangeSubtypes contains element types of the built-in range types
+var RangeSubtypes = map[string]string{
+	PgInt4Range: PgInteger,
+	PgInt8Range: PgBigint,
+	PgNumRange:  PgNumeric,
+	PgTsRange:   PgTimestampWithoutTimeZone,
+	PgTstzRange: PgTimestampWithTimeZone,
+	PgDateRange: PgDate,
+}
+
+// Range represents postgresql's range value; invalid Lower or Upper stand for the unbounded (infinite) sides
+type Range struct {
+	Lower    sql.NullString
+	Upper    sql.NullString
+	LowerInc bool
+	UpperInc bool
+	Empty    bool
+}
+
+// DecodeRange parses range text representation, e.g. [1,10), (,"2020-01-01 00:00:00"] or empty
+func DecodeRange(str string) (Range, error) {
+	var res Range
+
+	str = strings.TrimSpace(str)
+	if strings.EqualFold(str, rangeEmpty) {
+		res.Empty = true
+		return res, nil
+	}
+
+	if len(str) < 3 {
+		return res, fmt.Errorf("malformed range: %q", str)
+	}
+
+	switch str[0] {
+	case '[':
+		res.LowerInc = true
+	case '(':
+	default:
+		return res, fmt.Errorf("range must start with \"[\" or \"(\": %q", str)
+	}
+
+	switch str[len(str)-1] {
+	case ']':
+		res.UpperInc = true
+	case ')':
+	default:
+		return res, fmt.Errorf("range must end with \"]\" or \")\": %q", str)
+	}
+
+	p := &arrayParser{src: str[:len(str)-1], pos: 1}
+
+	var err error
+	if res.Lower, err = p.parseRecordItem(); err != nil {
+		return res, fmt.Errorf("could not parse %q range: %v", str, err)
+	}
+
+	if p.peek() != ',' {
+		return res, fmt.Errorf("could not parse %q range: expected \",\" at position %d", str, p.pos)
+	}
+	p.pos++
+
+	if res.Upper, err = p.parseRecordItem(); err != nil {
+		return res, fmt.Errorf("could not parse %q range: %v", str, err)
+	}
+
+	if !p.eof() {
+		return res, fmt.Errorf("could not parse %q range: junk at position %d", str, p.pos)
+	}
+
+	return res, nil
+}
+
+// Bounds returns inclusivity of the range bounds, e.g. [) or (], or empty for the empty range
+func (r Range) Bounds() string {
+	if r.Empty {
+		return rangeEmpty
+	}
+
+	bounds := []byte("()")
+	if r.LowerInc {
+		bounds[0] = '['
+	}
+	if r.UpperInc {
+		bounds[1] = ']'
+	}
+
+	return string(bounds)
+}
+
+// parseRecordItem parses either quoted or unquoted range bound or composite type field,
+// missing item stands for the unbounded side or null
+func (p *arrayParser) parseRecordItem() (sql.NullString, error) {
+	str := &strings.Builder{}
+	quoted := false
+
+	for !p.eof() {
+		ch := p.src[p.pos]
+		if ch == ',' {
+			break
+		}
+		p.pos++
+
+		switch ch {
+		case '"':
+			quoted = true
+			for {
+				if p.eof() {
+					return sql.NullString{}, fmt.Errorf("unterminated quoted item")
+				}
+
+				ch = p.src[p.pos]
+				p.pos++
+				if ch == '"' {
+					if p.peek() != '"' { // doubled quote stands for the quote itself
+						break
+					}
+					p.pos++
+				} else if ch == '\\' {
+					if p.eof() {
+						return sql.NullString{}, fmt.Errorf("unexpected end of input")
+					}
+					ch = p.src[p.pos]
+					p.pos++
+				}
+				str.WriteByte(ch)
+			}
+		case '\\':
+			if p.eof() {
+				return sql.NullString{}, fmt.Errorf("unexpected end of input")
+			}
+			str.WriteByte(p.src[p.pos])
+			p.pos++
+		default:
+			str.WriteByte(ch)
+		}
+	}
+
+	if str.Len() == 0 && !quoted { // empty quoted string is a valid item though
+		return sql.NullString{}, nil
+	}
+
+	return sql.NullString{Valid: true, String: str.String()}, nil
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestDecodeRange(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected Range
+		bounds   string
+		fails    bool
+	}{
+		{str: "[1,10)", expected: Range{Lower: str("1"), Upper: str("10"), LowerInc: true}, bounds: "[)"},
+		{str: "(1,10]", expected: Range{Lower: str("1"), Upper: str("10"), UpperInc: true}, bounds: "(]"},
+		{str: "[1,)", expected: Range{Lower: str("1"), Upper: null, LowerInc: true}, bounds: "[)"},
+		{str: "(,)", expected: Range{Lower: null, Upper: null}, bounds: "()"},
+		{str: "empty", expected: Range{Empty: true}, bounds: "empty"},
+		{str: " EMPTY ", expected: Range{Empty: true}, bounds: "empty"},
+		{str: `["2020-01-01 00:00:00","2020-01-02 00:00:00"]`,
+			expected: Range{Lower: str("2020-01-01 00:00:00"), Upper: str("2020-01-02 00:00:00"), LowerInc: true, UpperInc: true},
+			bounds:   "[]"},
+		{str: `["a""b","c\,d"]`, expected: Range{Lower: str(`a"b`), Upper: str("c,d"), LowerInc: true, UpperInc: true},
+			bounds: "[]"},
+		{str: `["",x)`, expected: Range{Lower: str(""), Upper: str("x"), LowerInc: true}, bounds: "[)"},
+		{str: "", fails: true},
+		{str: "[]", fails: true},
+		{str: "1,10", fails: true},
+		{str: "[1,10", fails: true},
+		{str: "[1)", fails: true},
+		{str: "[1,2,3)", fails: true},
+		{str: `["1,2)`, fails: true},
+	}
+
+	for _, tt := range tests {
+		res, err := DecodeRange(tt.str)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("DecodeRange(%q): expected error, got %+v", tt.str, res)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("DecodeRange(%q): unexpected error: %v", tt.str, err)
+			continue
+		}
+
+		if res != tt.expected {
+			t.Errorf("DecodeRange(%q) = %+v, expected %+v", tt.str, res, tt.expected)
+		}
+
+		if bounds := res.Bounds(); bounds != tt.bounds {
+			t.Errorf("DecodeRange(%q).Bounds() = %q, expected %q", tt.str, bounds, tt.bounds)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package tableinfo
 
 import (
+	"database/sql"
 	"fmt"
 	"strconv"
 	"strings"
@@ -12,10 +13,21 @@ import (
 	"github.com/mkabilov/pg2ch/pkg/utils"
 )
 
+const (
+	pgTypeComposite = "c"
+	pgTypeDomain    = "d"
+)
+
+// queryer is implemented by both pgx.Conn and pgx.Tx
+type queryer interface {
+	Query(sql string, args ...interface{}) (*pgx.Rows, error)
+}
+
 // TablePgColumns returns postgresql table's columns structure
 func TablePgColumns(tx *pgx.Tx, tblName config.PgTableName) ([]message.Column, map[string]config.PgColumn, error) {
 	columns := make([]message.Column, 0)
 	pgColumns := make(map[string]config.PgColumn)
+	userTypes := make([]message.Column, 0) // columns of the domain and composite types
 
 	rows, err := tx.Query(`select
   a.attname,
@@ -24,10 +36,22 @@ func TablePgColumns(tx *pgx.Tx, tblName config.PgTableName) ([]message.Column, m
   string_to_array(substring(format_type(a.atttypid, a.atttypmod) from '\((.*)\)'), ',') as ext,
   coalesce(ai.attnum, 0) as pk_attnum,
   a.atttypmod,
-  a.atttypid
+  a.atttypid,
+  (select array_agg(e.enumlabel order by e.enumsortorder)
+   from pg_enum e
+   where e.enumtypid in (t.oid, t.typelem)) as enum_values,
+  coalesce(et.typtype, t.typtype) in ('c', 'd') as is_user_type,
+  c.relreplident = 'f'
+    or (c.relreplident = 'd' and ai.attnum is not null)
+    or (c.relreplident = 'i' and exists(select 1
+                                        from pg_index ri
+                                        where ri.indrelid = c.oid and ri.indisreplident and a.attnum = any(ri.indkey)))
+    as is_replica_identity
 from pg_class c
   inner join pg_namespace n on n.oid = c.relnamespace
   inner join pg_attribute a on a.attrelid = c.oid
+  inner join pg_type t on t.oid = a.atttypid
+  left join pg_type et on et.oid = t.typelem and t.typcategory = 'A'
   left join pg_index i on i.indrelid = a.attrelid and i.indisprimary
   left join pg_attribute ai on ai.attrelid = i.indexrelid and ai.attname = a.attname and ai.attisdropped = false
 where
@@ -47,14 +71,25 @@ order by
 			colName, baseType string
 			pgColumn          config.PgColumn
 			extStr            []string
+			enumValues        []string
 			attTypMod         int32
 			attOID            utils.OID
+			isUserType        bool
+			isKey             bool
 		)
 
-		if err := rows.Scan(&colName, &pgColumn.IsNullable, &baseType, &extStr, &pgColumn.PkCol, &attTypMod, &attOID); err != nil {
+		if err := rows.Scan(&colName, &pgColumn.IsNullable, &baseType, &extStr, &pgColumn.PkCol, &attTypMod, &attOID,
+			&enumValues, &isUserType, &isKey); err != nil {
 			return nil, nil, fmt.Errorf("could not scan: %v", err)
 		}
 
+		if enumValues != nil {
+			pgColumn.EnumValues = make(map[string]int, len(enumValues))
+			for i, label := range enumValues {
+				pgColumn.EnumValues[label] = i + 1
+			}
+		}
+
 		if baseType[len(baseType)-2:] == "[]" {
 			pgColumn.IsArray = true
 			pgColumn.BaseType = baseType[:len(baseType)-2]
@@ -62,30 +97,261 @@ order by
 			pgColumn.BaseType = baseType
 		}
 
-		if extStr != nil {
-			pgColumn.Ext, err = strToIntArray(extStr)
-			if err != nil {
-				return nil, nil, fmt.Errorf("could not convert into int array: %v", err)
-			}
+		if err := setTypeParams(&pgColumn, extStr); err != nil {
+			return nil, nil, err
 		}
 
-		columns = append(columns, message.Column{
-			IsKey:   pgColumn.PkCol > 0,
+		column := message.Column{
+			IsKey:   isKey, // the same as in the relation message
 			Name:    colName,
 			TypeOID: attOID,
 			Mode:    attTypMod,
-		})
+		}
+		if isUserType {
+			userTypes = append(userTypes, column)
+		}
+
+		columns = append(columns, column)
 		pgColumns[colName] = pgColumn
 	}
 
+	// the types are resolved once the rows are read, the connection can't run queries while reading them
+	for _, column := range userTypes {
+		pgType, err := PgType(tx, column.TypeOID, column.Mode)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not resolve type of the %q column: %v", column.Name, err)
+		}
+
+		pgColumns[column.Name] = SetColumnType(pgColumns[column.Name], pgType)
+	}
+
 	return columns, pgColumns, nil
 }
 
+// PgType describes the postgresql type with the given type modifier: domains are resolved to their base types,
+// fields of the composite types are resolved as well
+func PgType(conn queryer, typeOID utils.OID, typMod int32) (config.PgColumn, error) {
+	var (
+		pgType               config.PgColumn
+		typType, typeName    string
+		baseTypeOID, elemOID utils.OID
+		relOID               utils.OID
+		baseTypMod           int32
+		isArray              bool
+		extStr, enumValues   []string
+	)
+
+	rows, err := conn.Query(`select
+  t.typtype::text,
+  t.oid::regtype::text,
+  t.typbasetype,
+  t.typtypmod,
+  t.typrelid,
+  t.typelem,
+  t.typcategory = 'A' as is_array,
+  string_to_array(substring(format_type(t.oid, $2) from '\((.*)\)'), ',') as ext,
+  (select array_agg(e.enumlabel order by e.enumsortorder)
+   from pg_enum e
+   where e.enumtypid = t.oid) as enum_values
+from pg_type t
+where
+  t.oid = $1`, typeOID, typMod)
+	if err != nil {
+		return pgType, fmt.Errorf("could not query: %v", err)
+	}
+
+	found := false
+	for rows.Next() {
+		found = true
+		if err := rows.Scan(&typType, &typeName, &baseTypeOID, &baseTypMod, &relOID, &elemOID, &isArray,
+			&extStr, &enumValues); err != nil {
+			rows.Close()
+			return pgType, fmt.Errorf("could not scan: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return pgType, err
+	}
+	if !found {
+		return pgType, fmt.Errorf("type with %v oid not found", typeOID)
+	}
+
+	switch {
+	case isArray:
+		if pgType, err = PgType(conn, elemOID, typMod); err != nil {
+			return pgType, err
+		}
+		pgType.IsArray = true
+
+		return pgType, nil
+	case typType == pgTypeDomain:
+		return PgType(conn, baseTypeOID, baseTypMod)
+	case typType == pgTypeComposite:
+		pgType.BaseType = typeName
+		pgType.Fields, err = compositeFields(conn, relOID)
+
+		return pgType, err
+	}
+
+	pgType.BaseType = typeName
+	if enumValues != nil {
+		pgType.EnumValues = make(map[string]int, len(enumValues))
+		for i, label := range enumValues {
+			pgType.EnumValues[label] = i + 1
+		}
+	}
+
+	return pgType, setTypeParams(&pgType, extStr)
+}
+
+// SetColumnType sets the resolved type of the column keeping its nullability, primary key and timezone
+func SetColumnType(pgColumn config.PgColumn, pgType config.PgColumn) config.PgColumn {
+	pgType.IsNullable = pgColumn.IsNullable
+	pgType.PkCol = pgColumn.PkCol
+	pgType.TimeZone = pgColumn.TimeZone
+
+	return pgType
+}
+
+func compositeFields(conn queryer, relOID utils.OID) ([]config.PgField, error) {
+	rows, err := conn.Query(`select
+  a.attname,
+  a.atttypid,
+  a.atttypmod
+from pg_attribute a
+where
+  a.attrelid = $1
+  and a.attnum > 0
+  and a.attisdropped = false
+order by
+  a.attnum`, relOID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query: %v", err)
+	}
+
+	columns := make([]message.Column, 0)
+	for rows.Next() {
+		var column message.Column
+		if err := rows.Scan(&column.Name, &column.TypeOID, &column.Mode); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not scan: %v", err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	fields := make([]config.PgField, 0, len(columns))
+	for _, column := range columns {
+		pgType, err := PgType(conn, column.TypeOID, column.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve type of the %q field: %v", column.Name, err)
+		}
+		pgType.IsNullable = true // fields of the composite types can't have not null constraints
+
+		fields = append(fields, config.PgField{PgColumn: pgType, Name: column.Name})
+	}
+
+	return fields, nil
+}
+
+// setTypeParams sets the type modifiers, e.g. precision and scale of the numeric or PostGIS geometry type
+func setTypeParams(pgColumn *config.PgColumn, extStr []string) error {
+	if extStr == nil {
+		return nil
+	}
+
+	if pgColumn.BaseType == utils.PgGeometry || pgColumn.BaseType == utils.PgGeography {
+		// geometry(Point, 4326) has no numeric modifiers
+		return nil
+	}
+
+	ext, err := strToIntArray(extStr)
+	if err != nil {
+		return fmt.Errorf("could not convert into int array: %v", err)
+	}
+	pgColumn.Ext = ext
+
+	return nil
+}
+
+// SetTimeStats sets the earliest and the latest finite values of the date and timestamp columns
+// according to the pg_stats; values of the columns without statistics are left zero
+func SetTimeStats(tx *pgx.Tx, tblName config.PgTableName, pgColumns map[string]config.PgColumn) error {
+	rows, err := tx.Query(`select
+  attname,
+  coalesce(histogram_bounds::text, '{}'),
+  coalesce(most_common_vals::text, '{}')
+from pg_stats
+where
+  schemaname = $1
+  and tablename = $2
+  and not inherited`, tblName.SchemaName, tblName.TableName)
+	if err != nil {
+		return fmt.Errorf("could not query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var colName, histogramBounds, mostCommonVals string
+
+		if err := rows.Scan(&colName, &histogramBounds, &mostCommonVals); err != nil {
+			return fmt.Errorf("could not scan: %v", err)
+		}
+
+		pgColumn, ok := pgColumns[colName]
+		if !ok || pgColumn.IsArray || !isTimeType(pgColumn.BaseType) {
+			continue
+		}
+
+		for _, vals := range []string{histogramBounds, mostCommonVals} {
+			items, err := utils.DecodeArray(vals)
+			if err != nil {
+				return fmt.Errorf("could not decode statistics of the %q column: %v", colName, err)
+			}
+
+			for _, item := range items {
+				val, ok := item.(sql.NullString)
+				if !ok || !val.Valid {
+					continue
+				}
+
+				t, err := utils.ParseTimestamp(val.String, pgColumn.TimeZone)
+				if err != nil {
+					return fmt.Errorf("could not parse statistics of the %q column: %v", colName, err)
+				}
+
+				if t.Equal(utils.PgInfinity) || t.Equal(utils.PgNegativeInfinity) {
+					pgColumn.HasInfinity = true
+					continue
+				}
+
+				if pgColumn.MinTime.IsZero() || t.Before(pgColumn.MinTime) {
+					pgColumn.MinTime = t
+				}
+				if pgColumn.MaxTime.IsZero() || t.After(pgColumn.MaxTime) {
+					pgColumn.MaxTime = t
+				}
+			}
+		}
+
+		pgColumns[colName] = pgColumn
+	}
+
+	return rows.Err()
+}
+
+func isTimeType(pgType string) bool {
+	return pgType == utils.PgDate || pgType == utils.PgTimestamp ||
+		pgType == utils.PgTimestampWithTimeZone || pgType == utils.PgTimestampWithoutTimeZone
+}
+
 func strToIntArray(str []string) ([]int, error) {
 	var err error
 	ints := make([]int, len(str))
 	for i, strVal := range str {
-		ints[i], err = strconv.Atoi(strVal)
+		ints[i], err = strconv.Atoi(strings.TrimSpace(strVal))
 		if err != nil {
 			return nil, err
 		}
@@ -95,29 +361,174 @@ func strToIntArray(str []string) ([]int, error) {
 }
 
 func parseChType(chType string) (col config.Column) {
-	if strings.HasPrefix(chType, "LowCardinality(") {
-		chType = chType[15 : len(chType)-1]
+	col = config.Column{BaseType: stripLowCardinality(chType), IsArray: false, IsNullable: false}
+
+	// SimpleAggregateFunction(sum, T) columns take the values of the T type,
+	// AggregateFunction(sum, T) ones are filled from the buffer table of the T type columns
+	for _, prefix := range []string{"SimpleAggregateFunction(", "AggregateFunction("} {
+		if strings.HasPrefix(chType, prefix) {
+			if params := splitTypeParams(chType[len(prefix) : len(chType)-1]); len(params) == 2 {
+				return parseChType(strings.TrimSpace(params[1]))
+			}
+		}
 	}
 
-	col = config.Column{BaseType: chType, IsArray: false, IsNullable: false}
+	for strings.HasPrefix(col.BaseType, "Array(") {
+		col.IsArray = true
+		col.ArrayDepth++
+		col.BaseType = stripLowCardinality(col.BaseType[6 : len(col.BaseType)-1])
+	}
 
-	if ln := len(chType); ln >= 7 {
-		if strings.HasPrefix(chType, "Array(Nullable(") {
-			col = config.Column{BaseType: chType[15 : ln-2], IsArray: true, IsNullable: true}
-		} else if strings.HasPrefix(chType, "Array(") {
-			col = config.Column{BaseType: chType[6 : ln-1], IsArray: true, IsNullable: false}
-		} else if strings.HasPrefix(chType, "Nullable(") {
-			col = config.Column{BaseType: chType[9 : ln-1], IsArray: false, IsNullable: true}
-		}
+	if strings.HasPrefix(col.BaseType, "Nullable(") {
+		col.IsNullable = true
+		col.BaseType = col.BaseType[9 : len(col.BaseType)-1]
 	}
 
 	if strings.HasPrefix(col.BaseType, "FixedString(") {
-		col.BaseType = "FixedString"
+		if length, err := strconv.Atoi(col.BaseType[12 : len(col.BaseType)-1]); err == nil {
+			col.Ext = []int{length}
+		}
+		col.BaseType = utils.ChFixedString
 	}
 
-	if strings.HasPrefix(col.BaseType, "Decimal(") {
-		col.BaseType = "Decimal"
+	if strings.HasPrefix(col.BaseType, "DateTime64(") {
+		// DateTime64(precision[, timezone])
+		params := strings.Split(col.BaseType[11:len(col.BaseType)-1], ",")
+		if precision, err := strconv.Atoi(strings.TrimSpace(params[0])); err == nil {
+			col.Ext = []int{precision}
+		}
+		col.BaseType = utils.ChDateTime64
+	} else if strings.HasPrefix(col.BaseType, "DateTime(") {
+		// DateTime(timezone)
+		col.BaseType = utils.ChDateTime
+	}
+
+	if strings.HasPrefix(col.BaseType, "Enum8(") || strings.HasPrefix(col.BaseType, "Enum16(") {
+		idx := strings.IndexByte(col.BaseType, '(')
+		col.EnumValues = parseEnumValues(col.BaseType[idx+1 : len(col.BaseType)-1])
+		col.BaseType = col.BaseType[:idx]
+	}
+
+	if strings.HasPrefix(col.BaseType, "Decimal") {
+		col.Ext = parseDecimalParams(col.BaseType)
+		col.BaseType = utils.ChDecimal
 	}
 
 	return
 }
+
+// splitTypeParams splits parameters of the composite type by the top level commas
+func splitTypeParams(str string) []string {
+	var (
+		res      []string
+		depth    int
+		inQuotes bool
+		start    int
+	)
+
+	for i := 0; i < len(str); i++ {
+		switch ch := str[i]; {
+		case inQuotes && ch == '\\':
+			i++
+		case ch == '\'':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			res = append(res, strings.TrimSpace(str[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(res, strings.TrimSpace(str[start:]))
+}
+
+// parseDecimalParams returns precision and scale of the Decimal(P, S), Decimal32(S), ..., Decimal256(S) types
+func parseDecimalParams(chType string) []int {
+	var precision int
+
+	idx := strings.IndexByte(chType, '(')
+	if idx < 0 {
+		return nil
+	}
+
+	params, err := strToIntArray(strings.Split(chType[idx+1:len(chType)-1], ","))
+	if err != nil {
+		return nil
+	}
+
+	switch chType[:idx] {
+	case utils.ChDecimal:
+		if len(params) != 2 {
+			return nil
+		}
+
+		return params
+	case utils.ChDecimal32:
+		precision = utils.MaxDecimal32Precision
+	case utils.ChDecimal64:
+		precision = utils.MaxDecimal64Precision
+	case utils.ChDecimal128:
+		precision = utils.MaxDecimal128Precision
+	case utils.ChDecimal256:
+		precision = utils.MaxDecimal256Precision
+	default:
+		return nil
+	}
+
+	if len(params) != 1 {
+		return nil
+	}
+
+	return []int{precision, params[0]}
+}
+
+func stripLowCardinality(chType string) string {
+	if strings.HasPrefix(chType, "LowCardinality(") {
+		return chType[15 : len(chType)-1]
+	}
+
+	return chType
+}
+
+// parseEnumValues parses enum definition, e.g. 'a' = 1, 'b' = 2
+func parseEnumValues(str string) map[string]int {
+	result := make(map[string]int)
+
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\'' {
+			continue
+		}
+
+		label := &strings.Builder{}
+		for i++; i < len(str) && str[i] != '\''; i++ {
+			if str[i] == '\\' && i+1 < len(str) {
+				i++
+			}
+			label.WriteByte(str[i])
+		}
+
+		if i >= len(str) {
+			return nil
+		}
+
+		codeEnd := strings.IndexByte(str[i:], ',')
+		if codeEnd < 0 {
+			codeEnd = len(str) - i
+		}
+
+		codeStr := strings.TrimSpace(str[i+1 : i+codeEnd])
+		code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(codeStr, "=")))
+		if err != nil {
+			return nil
+		}
+
+		result[label.String()] = code
+		i += codeEnd
+	}
+
+	return result
+}
@@ -15,8 +15,17 @@ const (
 	ChString      = "String"
 	ChDate        = "Date"
 	ChDateTime    = "DateTime"
+	ChDateTime64  = "DateTime64"
 	ChDecimal     = "Decimal"
+	ChDecimal32   = "Decimal32"
+	ChDecimal64   = "Decimal64"
+	ChDecimal128  = "Decimal128"
+	ChDecimal256  = "Decimal256"
 	ChUUID        = "UUID"
+	ChEnum8       = "Enum8"
+	ChEnum16      = "Enum16"
+	ChIPv4        = "IPv4"
+	ChIPv6        = "IPv6"
 	ChUInt8Array  = "Array(UInt8)"
 
 	PgSmallint                 = "smallint"
@@ -45,4 +54,19 @@ const (
 	PgUuid                     = "uuid"
 	PgBytea                    = "bytea"
 	PgInet                     = "inet"
+	PgCidr                     = "cidr"
+	PgMacAddr                  = "macaddr"
+	PgMacAddr8                 = "macaddr8"
+	PgHstore                   = "hstore"
+	PgInt4Range                = "int4range"
+	PgInt8Range                = "int8range"
+	PgNumRange                 = "numrange"
+	PgTsRange                  = "tsrange"
+	PgTstzRange                = "tstzrange"
+	PgDateRange                = "daterange"
+	PgPoint                    = "point"
+	PgBox                      = "box"
+	PgPolygon                  = "polygon"
+	PgGeometry                 = "geometry"
+	PgGeography                = "geography"
 )